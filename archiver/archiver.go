@@ -1,6 +1,7 @@
 package archiver
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
@@ -9,9 +10,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/imroc/req/v3"
 	"github.com/rs/zerolog/log"
 
+	archiveapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/app/archive/v1"
+
 	"github.com/XiaoMiku01/bilibili-archiver/internal"
 )
 
@@ -19,15 +21,22 @@ type ArchiverUser struct {
 	config internal.Config
 	buser  internal.UserInfoStruct
 
-	bapi       *internal.BApiClient
-	firstRound bool // 添加标志，表示是否完成第一轮处理
+	bapi       internal.BiliAPI
+	dm         internal.Downloader    // 所有账号共用的下载管理器
+	store      *internal.ArchiveStore // 留档状态数据库
+	firstRound bool                   // 添加标志，表示是否完成第一轮处理
+	primary    bool                   // 第一个账号, 负责更新没有账号信息的旧记录
 }
 
-func NewArchiverUser(config internal.Config) *ArchiverUser {
+// 每个账号使用独立的 API 客户端, 通常为 internal.NewBApiClient(), 限速器、下载管理器和数据库由所有账号共用
+func NewArchiverUser(config internal.Config, bapi internal.BiliAPI, dm internal.Downloader, store *internal.ArchiveStore, primary bool) *ArchiverUser {
 	return &ArchiverUser{
 		config:     config,
-		bapi:       internal.BApi,
+		bapi:       bapi,
+		dm:         dm,
+		store:      store,
 		firstRound: true, // 初始化为false，表示第一轮未完成
+		primary:    primary,
 	}
 }
 
@@ -41,6 +50,9 @@ func (au *ArchiverUser) Init() error {
 		return err
 	}
 	au.buser = buser
+	au.dm.RegisterClient(au.mid(), au.bapi)
+	au.dm.RegisterGroupRestorer(au.mid(), au.restoreTaskGroup)
+	au.importMetaFiles() // 首次运行时导入存储目录中已有的元数据文件, 按账号顺序执行
 
 	log.Info().Msgf("用户: %s [UID: %d] 登录成功", au.buser.Uname, au.buser.Mid)
 
@@ -48,28 +60,36 @@ func (au *ArchiverUser) Init() error {
 	return nil
 }
 
-// 关键词过滤收藏夹
-func (au *ArchiverUser) FillerFavoriteList(fvl internal.FavListStruct, kw []string) internal.FavListStruct {
-	if len(kw) == 0 {
-		return fvl
-	}
+func (au *ArchiverUser) mid() int64 {
+	return int64(au.buser.Mid)
+}
+
+// 按收藏夹规则和关键词过滤收藏夹, 收藏的合集按合集类型匹配规则
+func (au *ArchiverUser) FillerFavoriteList(fvl internal.FavListStruct) internal.FavListStruct {
 	var favList internal.FavListStruct
 	for _, fav := range fvl.List {
-		for _, k := range kw {
-			if strings.Contains(fav.Title, k) {
-				favList.List = append(favList.List, fav)
-			}
+		kind := sourceFav
+		if fav.Type == 21 {
+			kind = sourceSeason
+		}
+		if au.config.FolderEnabled(kind, int64(fav.ID), fav.Title) {
+			favList.List = append(favList.List, fav)
 		}
 	}
 	return favList
 }
 
-func (au *ArchiverUser) Run() {
+// 循环处理所有来源, ctx 取消后在当前投稿处理完成时返回
+func (au *ArchiverUser) Run(ctx context.Context) {
 	startTime := int(time.Now().Unix()) // 程序启动时间
 	var lastRoundTime int = startTime   // 记录上一轮结束的时间
-	go internal.DM.Run()                // 启动下载管理器
-	go au.UpdateVideoMeta()
-	for {
+	updaterDone := make(chan struct{})
+	go func() {
+		au.UpdateVideoMeta(ctx)
+		close(updaterDone)
+	}()
+	defer func() { <-updaterDone }() // 等待元数据更新退出
+	for ctx.Err() == nil {
 		// currentTime := lastRoundTime // 使用上一轮结束时间作为基准
 
 		// 定义一个变量表示是否全量处理
@@ -82,248 +102,396 @@ func (au *ArchiverUser) Run() {
 			isFull = false
 		}
 		au.bapi.InitGRPC()
-		favs, err := au.bapi.GetFavList(au.buser.Mid)
-		if err != nil {
-			log.Error().Err(err).Msg("获取收藏夹列表失败")
-			continue
+		au.archiveFavorites(ctx, isFull, lastRoundTime) // 收藏夹
+		if au.config.Collected {
+			au.archiveCollected(ctx, isFull, lastRoundTime) // 收藏的收藏夹和合集
 		}
-		// 过滤收藏夹
-		favs = au.FillerFavoriteList(favs, au.config.Keywords)
-		log.Info().Msgf("过滤后收藏夹数量: %d , 过滤关键词: %v ", len(favs.List), au.config.Keywords)
-		for _, fav := range favs.List {
-			log.Info().Msgf("开始处理收藏夹: %s", fav.Title)
-
-			// 获取收藏夹投稿
-			for pn := 1; pn <= fav.MediaCount/40+1; pn++ {
-				// au.bapi.GetFavMediaList(fav)
-				favMediaList, err := au.bapi.GetFavMediaList(fav.ID, pn)
-				if err != nil {
-					log.Error().Err(err).Msgf("获取收藏夹投稿: %s pn:%d 失败", fav.Title, pn)
-					pn--
-					time.Sleep(10 * time.Second)
-					continue
-				}
-
-				if len(favMediaList.Medias) == 0 {
-					// log.Debug().Msgf("收藏夹: %s pn:%d 失败", fav.Title, pn)
-					if pn == 1 {
-						log.Warn().Msgf("收藏夹: %s pn:%d 无投稿", fav.Title, pn)
-					}
-					break
-				} else {
-					// 如果不是全量处理，且最近的 FavTime 小于上一轮结束时间，跳出循环
-					if !isFull && favMediaList.Medias[0].FavTime < lastRoundTime {
-						log.Debug().Msgf("上一次处理时间: %s, 最近稿件时间: %s", internal.FormatTime(lastRoundTime), internal.FormatTime(favMediaList.Medias[0].FavTime))
-						break
-					}
-				}
-
-				for _, media := range favMediaList.Medias {
-					// TODO: 过滤 PGC
-					if media.Ugc.FirstCid == 0 {
-						continue
-					}
-
-					if !isFull && media.FavTime < lastRoundTime {
-						log.Debug().Msgf("上一次处理时间: %s, 当前稿件时间: %s, 退出遍历", internal.FormatTime(lastRoundTime), internal.FormatTime(favMediaList.Medias[0].FavTime))
-						break
-					}
-
-					log.Info().Msgf("开始处理投稿: %s", media.Title)
-					vinfo, err := au.bapi.GetView(&internal.ViewReq{
-						Aid: media.ID,
-					})
-					if err != nil {
-						log.Error().Err(err).Msgf("获取投稿信息失败: %s", media.Title)
-						continue
-					}
-					// 当稿件失效或信息为空时跳过以避免空指针
-					if vinfo.Arc == nil || vinfo.Ecode != 0 {
-						log.Warn().Msgf("稿件已失效: %s", media.Title)
-						continue
-					}
-
-					// 路径模板替换
-
-					// pdir := filepath.Dir(filepath.Join(au.config.SavePath, dirpath)) // 获取父目录 保存元数据
-					au.downloadVideMeta(fav.Title, vinfo, media.FavTime) // 下载投稿元数据
-					au.downloadVideo(fav.Title, vinfo, media)            // 下载投稿
-					// time.Sleep(10 * time.Second)
-				}
-				// 获取分页 time.sleep
-				log.Debug().Msgf("收藏夹: %s pn:%d 处理完成", fav.Title, pn)
-				// time.Sleep(10 * time.Second)
-				if isFull {
-					time.Sleep(10 * time.Second)
-				}
-			}
-			// 收藏夹处理完成 time.sleep
-			log.Debug().Msgf("收藏夹: %s 处理完成", fav.Title)
-			// time.Sleep(10 * time.Second)
+		au.archiveUppers(ctx, isFull, lastRoundTime) // 监控的UP主投稿
+		if au.config.ToView {
+			au.archiveToView(ctx, isFull, lastRoundTime) // 稍后再看
+		}
+		au.archiveVirtualFolders(ctx, isFull, lastRoundTime) // 点赞、投币和历史记录
+		if ctx.Err() != nil {
+			break
 		}
 
 		// 最外层获取收藏夹循环 time.sleep
-		log.Info().Msg("所有收藏夹处理完成, 休眠中...")
+		log.Info().Msg("所有来源处理完成, 休眠中...")
 		// au.bapi.CloseGRPC()
 		// 第一轮已完成，设置标志
 		au.firstRound = false
 		// 更新 lastRoundTime 为当前时间，这样下次循环会处理这段时间内的新投稿
 		lastRoundTime = int(time.Now().Unix())
-		time.Sleep(time.Duration(au.config.ScanInterval) * time.Minute)
+		if internal.SleepContext(ctx, time.Duration(au.config.ScanInterval)*time.Minute) != nil {
+			break
+		}
 		au.bapi.GetUserInfo() // 检查登录状态
 		tinfo, _ := au.bapi.CheckToken()
 		exTime := tinfo.ExpiresIn / 86400
 		log.Debug().Msgf("Cookie 有效期: %d 天", exTime)
 		if exTime < 7 {
 			// au.bapi.RefreshToken()
-			log.Warn().Msg("Cookie 即将过期，刷新中...")
-			internal.RefreshToken(au.config.User)
+			log.Warn().Msgf("%s Cookie 即将过期，刷新中...", au.buser.Uname)
+			au.bapi.AutoRefreshCookie()
 		}
 	}
 }
 
-func (au *ArchiverUser) downloadVideo(favname string, vinfo *internal.ViewReply, media internal.FavMediaStruct) error {
-	groupID := vinfo.Bvid
+// 根据路径模板生成分P的保存路径(不含扩展名)
+func (au *ArchiverUser) pagePath(src videoSource, vinfo *internal.ViewReply, favtime, pn int) string {
+	return au.fillPath(src, map[string]string{
+		"date":        internal.FormatDate(favtime),
+		"video_title": vinfo.Arc.Title,
+		"bv":          vinfo.Bvid,
+		"upper_name":  vinfo.Arc.Author.Name,
+		"pn":          fmt.Sprintf("%d", pn),
+	})
+}
 
-	// 注册任务组，设置回调函数
-	internal.DM.RegisterTaskGroup(groupID, len(vinfo.Pages), func(id, pdir string) {
-		if len(vinfo.Pages) > 1 {
-			log.Info().Msgf("%s 所有分P下载完成", vinfo.Arc.Title)
-		}
-		// 执行自定义脚本和通知
-		if au.config.CustomScript != "" {
-			go internal.ExecCommand(au.config.CustomScript, pdir)
+// 填充路径模板, 补充来源相关的变量
+func (au *ArchiverUser) fillPath(src videoSource, values map[string]string) string {
+	values["uname"] = au.buser.Uname
+	values["source"] = src.Name
+	values["fav_name"] = src.Name
+	dirpath := internal.FillTemplatePath(src.Settings.PathTemplate, values)
+	return filepath.Join(au.config.SavePath, dirpath)
+}
+
+// 根据留档状态数据库判断投稿是否已全部留档, 无需请求投稿信息
+func (au *ArchiverUser) isArchived(src videoSource, media internal.FavMediaStruct) bool {
+	rec, ok := au.store.GetVideo(media.ID)
+	if !ok || !au.hasSource(rec, src) {
+		return false
+	}
+	var n int
+	for _, p := range rec.Pages {
+		if p.Archived() {
+			n++
 		}
+	}
+	// 部分来源 (如UP主投稿列表) 不返回分P数量, 以上次记录的分P数量为准
+	return n > 0 && n >= max(media.Page, rec.PageCount)
+}
 
-		// 通知
-		if au.config.Notification != "" {
-			msg := `%s-%s.%s (%dP)
-已留档完成
-%s
-`
-			msg = fmt.Sprintf(msg, vinfo.Bvid, vinfo.Arc.Title, vinfo.Arc.Author.Name, len(vinfo.Pages), internal.FormatTime(int(time.Now().Unix())))
-			log.Info().Msg(msg)
-			err := internal.SendNotification(au.config.Notification, msg, au.config.NotificationProxy)
-			if err != nil {
-				log.Error().Err(err).Msg("发送通知失败")
-			} else {
-				log.Info().Msg("发送通知成功")
+// 判断分P是否已在该路径留档, 数据库中没有记录但最终文件已存在时补录
+func (au *ArchiverUser) pageArchived(aid int64, pn int, cid int64, path string) bool {
+	var linked bool // 分P已在其他路径留档, 该路径只能记录为链接文件
+	if rec, ok := au.store.GetVideo(aid); ok {
+		if p := rec.GetPage(cid); p != nil {
+			if p.Status == internal.PageStatusDone && p.Path == path {
+				return p.Archived()
+			}
+			if file, ok := p.LinkAt(path); ok {
+				if _, err := os.Stat(file); err == nil {
+					return true
+				}
+			}
+			if p.IsWaiting(path) {
+				return true
 			}
+			linked = p.Path != path && p.Status != internal.PageStatusFailed
+		}
+	}
+	var file string
+	var fi os.FileInfo
+	for _, ext := range []string{".mp4", ".mkv", ".m4a", ".flac"} {
+		if info, err := os.Stat(path + ext); err == nil {
+			file, fi = path+ext, info
+			break
+		}
+	}
+	if fi == nil {
+		return false
+	}
+	err := au.store.UpdateVideo(aid, func(rec *internal.VideoRecord) {
+		if p := rec.GetPage(cid); linked && p != nil {
+			p.AddLink(file)
+			return
 		}
+		rec.SetPage(internal.PageRecord{
+			Cid:    cid,
+			Page:   pn,
+			Path:   path,
+			Status: internal.PageStatusDone,
+			File:   file,
+			Size:   fi.Size(),
+		})
 	})
+	if err != nil {
+		log.Error().Err(err).Msgf("记录分P信息失败: %s", path)
+	}
+	return true
+}
 
+// 获取尚未留档的分P下标
+func (au *ArchiverUser) pendingPages(src videoSource, vinfo *internal.ViewReply, favtime int) []int {
+	var pages []int
 	for i, p := range vinfo.Pages {
+		if !au.pageArchived(vinfo.Arc.Aid, i+1, p.Page.Cid, au.pagePath(src, vinfo, favtime, i+1)) {
+			pages = append(pages, i)
+		}
+	}
+	return pages
+}
+
+// 添加分P下载任务, 获取播放地址失败时返回最后一个错误, 之后的轮次重新处理该投稿
+func (au *ArchiverUser) downloadVideo(ctx context.Context, src videoSource, vinfo *internal.ViewReply, media internal.FavMediaStruct, pages []int) error {
+	groupID := src.TaskGroupID(vinfo.Bvid)
+	var lastErr error
+
+	var groupPages []internal.TaskGroupPage
+	for _, i := range pages {
+		groupPages = append(groupPages, internal.TaskGroupPage{Cid: vinfo.Pages[i].Page.Cid, Label: fmt.Sprintf("P%d", i+1)})
+	}
+	header := fmt.Sprintf("%s-%s.%s (%dP)", vinfo.Bvid, vinfo.Arc.Title, vinfo.Arc.Author.Name, len(vinfo.Pages))
+	au.registerTaskGroup(ctx, src, groupID, vinfo.Arc.Title, header, groupPages)
+	metaPath := au.pagePath(src, vinfo, media.FavTime, 1) + "_meta.json"
+	streams := readStreams(metaPath)
+	defer func() {
+		if err := writeMeta(metaPath, videoMeta{Arc: vinfo.Arc, Streams: streams}); err != nil {
+			log.Error().Err(err).Msgf("记录音视频流失败: %s", metaPath)
+		}
+	}()
+
+	for _, i := range pages {
+		p := vinfo.Pages[i]
 		log.Info().Msgf("投稿信息: %s: P%d: cid: %d", vinfo.Bvid, i+1, p.Page.Cid)
-		playInfo, err := au.bapi.GetPlayURL(vinfo.Arc.Aid, p.Page.Cid)
+		dirpath := au.pagePath(src, vinfo, media.FavTime, i+1)
+		policy := src.Settings.Policy
+		playInfo, err := au.bapi.GetPlayURL(vinfo.Arc.Aid, p.Page.Cid, policy.Fnval())
+		if ctx.Err() != nil {
+			return ctx.Err() // 程序退出导致的失败不计入结果, 下次启动时重新处理
+		}
 		if err != nil {
 			log.Error().Err(err).Msgf("获取投稿播放信息失败: %s P%d", media.Title, i+1)
+			lastErr = fmt.Errorf("获取播放信息失败: %v", err)
+			au.dm.ReportTaskResult(groupID, p.Page.Cid, filepath.Dir(dirpath), lastErr)
 			continue
 		}
-		if len(playInfo.Dash.Video) == 0 || len(playInfo.Dash.Audio) == 0 {
-			log.Error().Msgf("投稿播放信息为空: %s P%d", media.Title, i+1)
+		sel, err := playInfo.SelectStreams(policy)
+		if err != nil {
+			log.Error().Err(err).Msgf("选择音视频流失败: %s P%d", media.Title, i+1)
+			lastErr = err
+			au.dm.ReportTaskResult(groupID, p.Page.Cid, filepath.Dir(dirpath), err)
 			continue
 		}
-		var quality int
-		var vurls internal.DownloadUrls
-		var aurls internal.DownloadUrls
-		if len(playInfo.Dash.Video) != 0 && len(playInfo.Dash.Audio) != 0 {
-			quality = int(playInfo.Dash.Video[0].ID)
-			vurls.Url = playInfo.Dash.Video[0].BackupURL[0]
-			aurls.Url = playInfo.Dash.Audio[0].BackupURL[0]
-			if len(playInfo.Dash.Video[0].BackupURL) != 0 && len(playInfo.Dash.Audio[0].BackupURL) != 0 {
-				vurls.BackupUrl = playInfo.Dash.Video[0].BackupURL[0]
-				aurls.BackupUrl = playInfo.Dash.Audio[0].BackupURL[0]
-			}
-		}
-
-		var qualityStr string = "画质未知"
-		for _, d := range playInfo.SupportFormats {
-			if d.Quality == quality {
-				qualityStr = d.NewDescription
-			}
-		}
-		dirpath := internal.FillTemplatePath(au.config.PathTemplate, map[string]string{
-			"uname":       au.buser.Uname,
-			"fav_name":    favname,
-			"date":        internal.FormatDate(media.FavTime),
-			"video_title": vinfo.Arc.Title,
-			"bv":          vinfo.Bvid,
-			"upper_name":  vinfo.Arc.Author.Name,
-			"pn":          fmt.Sprintf("%d", i+1),
-		})
+		log.Debug().Msgf("选择音视频流: %s P%d: %s %s %dx%d", media.Title, i+1, sel.Info.Description, sel.Info.VideoCodecs, sel.Info.Width, sel.Info.Height)
+		streams[fmt.Sprintf("P%d", i+1)] = sel.Info
 
 		downloaderTask := internal.DownloadTask{
 			GroupID:   groupID,
-			Title:     fmt.Sprintf("[%s]%s P%d", qualityStr, media.Title, i+1),
-			VideoUrls: vurls,
-			AudioUrls: aurls,
-			DirPath:   filepath.Join(au.config.SavePath, dirpath),
+			Mid:       au.mid(),
+			Aid:       vinfo.Arc.Aid,
+			Cid:       p.Page.Cid,
+			Title:     fmt.Sprintf("[%s]%s P%d", sel.Info.Description, media.Title, i+1),
+			VideoUrls: sel.Video,
+			AudioUrls: sel.Audio,
+			DirPath:   dirpath,
+			Policy:    policy,
+			Container: sel.Info.Container,
+			Duration:  sel.Info.Duration,
+		}
+		if policy.AudioOnly {
+			downloaderTask.Tags = &internal.AudioTags{
+				Title:  vinfo.Arc.Title,
+				Artist: vinfo.Arc.Author.Name,
+				Cover:  au.pagePath(src, vinfo, media.FavTime, 1) + "_cover.jpg",
+			}
+			if len(vinfo.Pages) > 1 {
+				downloaderTask.Tags.Title = fmt.Sprintf("P%d %s", i+1, p.Page.Part)
+				downloaderTask.Tags.Album = vinfo.Arc.Title
+			}
 		}
-		internal.DM.AddTask(&downloaderTask)
-
 		// 下载弹幕
-		if au.config.Danmaku {
-			dmList := au.downloadDanmaku(p.Page.Cid)
-			if len(dmList) != 0 {
-				var danmakuXml internal.DanmakuXmlstruct
-				danmakuXml.ChatServer = "chat.bilibili.com"
-				danmakuXml.ChatID = p.Page.Cid
-				danmakuXml.Mission = 0
-				danmakuXml.MaxLimit = len(dmList)
-				danmakuXml.State = 0
-				danmakuXml.RealName = 0
-				danmakuXml.Source = "k-v"
-				danmakuXml.Danmaku = internal.DM2XmlD(dmList)
-				xmlData, _ := xml.MarshalIndent(danmakuXml, "", "    ")
-				danmakuPath := filepath.Join(au.config.SavePath, dirpath+"_danmaku.xml")
-				f, err := os.Create(danmakuPath)
-				if err != nil {
-					log.Error().Err(err).Msgf("创建文件失败: %s", danmakuPath)
-				}
-				defer f.Close()
-				f.WriteString(xml.Header)
-				f.WriteString(string(xmlData))
-				log.Info().Msgf("保存弹幕完成: %s P%d (%d)条", media.Title, i+1, len(dmList))
+		if src.Settings.Danmaku {
+			au.saveDanmaku(p.Page.Cid, dirpath, fmt.Sprintf("%s P%d", media.Title, i+1))
+		}
+		if au.dedupePage(&downloaderTask, vinfo.Bvid, sel.Info) {
+			continue
+		}
+		if err := au.recordPendingPage(&downloaderTask, i+1, sel.Info); err != nil {
+			log.Error().Err(err).Msgf("记录分P信息失败: %s P%d", media.Title, i+1)
+		}
+		au.dm.AddTask(&downloaderTask)
+	}
+	return lastErr
+}
 
-			} else {
-				log.Warn().Msgf("尚未获取到弹幕: %s P%d", media.Title, i+1)
+// 下载弹幕并保存为 <dirpath>_danmaku.xml
+func (au *ArchiverUser) saveDanmaku(cid int64, dirpath, title string) {
+	dmList := au.downloadDanmaku(cid)
+	if len(dmList) == 0 {
+		log.Warn().Msgf("尚未获取到弹幕: %s", title)
+		return
+	}
+	var danmakuXml internal.DanmakuXmlstruct
+	danmakuXml.ChatServer = "chat.bilibili.com"
+	danmakuXml.ChatID = cid
+	danmakuXml.Mission = 0
+	danmakuXml.MaxLimit = len(dmList)
+	danmakuXml.State = 0
+	danmakuXml.RealName = 0
+	danmakuXml.Source = "k-v"
+	danmakuXml.Danmaku = internal.DM2XmlD(dmList)
+	xmlData, _ := xml.MarshalIndent(danmakuXml, "", "    ")
+	danmakuPath := dirpath + "_danmaku.xml"
+	f, err := os.Create(danmakuPath)
+	if err != nil {
+		log.Error().Err(err).Msgf("创建文件失败: %s", danmakuPath)
+		return
+	}
+	defer f.Close()
+	f.WriteString(xml.Header)
+	f.WriteString(string(xmlData))
+	log.Info().Msgf("保存弹幕完成: %s (%d)条", title, len(dmList))
+}
+
+// 注册任务组，设置回调函数
+func (au *ArchiverUser) registerTaskGroup(ctx context.Context, src videoSource, groupID, title, header string, pages []internal.TaskGroupPage) {
+	info := internal.TaskGroupInfo{
+		ID:         groupID,
+		Mid:        au.mid(),
+		Source:     src.Key(),
+		SourceName: src.Name,
+		Title:      title,
+		Header:     header,
+		Pages:      pages,
+	}
+	au.dm.RegisterTaskGroup(info, au.groupCallbacks(ctx, src.Settings, info))
+}
+
+// 程序重启后恢复任务组, 按来源重新解析生效的设置
+func (au *ArchiverUser) restoreTaskGroup(ctx context.Context, info internal.TaskGroupInfo) internal.TaskGroupCallbacks {
+	return au.groupCallbacks(ctx, au.sourceSettings(info.Source, info.SourceName, 0), info)
+}
+
+func (au *ArchiverUser) groupCallbacks(ctx context.Context, settings internal.FolderSettings, info internal.TaskGroupInfo) internal.TaskGroupCallbacks {
+	return internal.TaskGroupCallbacks{
+		OnSuccess: func(result internal.TaskGroupResult) {
+			if len(info.Pages) > 1 {
+				log.Info().Msgf("%s 所有分P下载完成", info.Title)
+			}
+			// 执行自定义脚本和通知
+			if settings.CustomScript != "" {
+				go internal.ExecCommand(ctx, settings.CustomScript, result.Dir)
 			}
+			au.notifyTaskGroup(info.Header, "已留档完成", info.Pages, result)
+		},
+		OnPartial: func(result internal.TaskGroupResult) {
+			log.Warn().Msgf("%s 部分分P下载失败 [%d/%d]", info.Title, len(result.Failed), result.Total)
+			au.notifyTaskGroup(info.Header, fmt.Sprintf("部分分P留档失败 (成功 %d/%d)", len(result.Succeeded), result.Total), info.Pages, result)
+		},
+		OnFailure: func(result internal.TaskGroupResult) {
+			log.Error().Msgf("%s 下载失败", info.Title)
+			au.notifyTaskGroup(info.Header, "留档失败", info.Pages, result)
+		},
+	}
+}
+
+// 发送任务组结果通知, 列出失败的分P
+func (au *ArchiverUser) notifyTaskGroup(header, status string, pages []internal.TaskGroupPage, result internal.TaskGroupResult) {
+	if au.config.Notification == "" {
+		return
+	}
+	var failed strings.Builder
+	for _, p := range pages {
+		if reason, ok := result.Failed[p.Cid]; ok {
+			failed.WriteString(fmt.Sprintf("%s: %s\n", p.Label, reason))
 		}
 	}
-	return nil
+	msg := `%s
+%s
+%s%s
+`
+	msg = fmt.Sprintf(msg, header, status, failed.String(), internal.FormatTime(int(time.Now().Unix())))
+	log.Info().Msg(msg)
+	err := internal.SendNotification(au.config.Notification, msg, au.config.NotificationProxy)
+	if err != nil {
+		log.Error().Err(err).Msg("发送通知失败")
+	} else {
+		log.Info().Msg("发送通知成功")
+	}
 }
 
-func (au *ArchiverUser) downloadVideMeta(favname string, vinfo *internal.ViewReply, favtime int) {
-	dirpath := internal.FillTemplatePath(au.config.PathTemplate, map[string]string{
-		"uname":       au.buser.Uname,
-		"fav_name":    favname,
-		"date":        internal.FormatDate(favtime),
-		"video_title": vinfo.Arc.Title,
-		"bv":          vinfo.Bvid,
-		"upper_name":  vinfo.Arc.Author.Name,
-		"pn":          fmt.Sprintf("%d", 1),
-	})
-	pdir := filepath.Dir(filepath.Join(au.config.SavePath, dirpath)) // 获取父目录 保存元数据
+// _meta.json 的内容: 投稿信息和各分P实际下载的音视频流, 键为分P序号 (如 P1)
+type videoMeta struct {
+	*archiveapi.Arc
+	Streams map[string]internal.StreamInfo `json:"streams,omitempty"`
+}
+
+// _season.json 的内容: 剧集信息和各集实际下载的音视频流, 键为集数标题
+type seasonMeta struct {
+	*internal.PGCSeasonStruct
+	Streams map[string]internal.StreamInfo `json:"streams,omitempty"`
+}
+
+// 读取元数据文件中已记录的音视频流, 文件不存在时返回空表
+func readStreams(path string) map[string]internal.StreamInfo {
+	var meta struct {
+		Streams map[string]internal.StreamInfo `json:"streams"`
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	if meta.Streams == nil {
+		meta.Streams = make(map[string]internal.StreamInfo)
+	}
+	return meta.Streams
+}
+
+func writeMeta(path string, meta any) error {
+	jsonData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, jsonData, 0644)
+}
+
+func (au *ArchiverUser) downloadVideMeta(src videoSource, vinfo *internal.ViewReply, favtime int) {
+	dirpath := au.pagePath(src, vinfo, favtime, 1)
+	pdir := filepath.Dir(dirpath) // 获取父目录 保存元数据
 	err := os.MkdirAll(pdir, os.ModePerm)
 	if err != nil {
 		log.Error().Err(err).Msgf("创建目录失败: %s", pdir)
 		return
 	}
-	filename := filepath.Join(au.config.SavePath, dirpath+"_meta.json")
-	jsonData, _ := json.MarshalIndent(vinfo.Arc, "", "  ")
-	f, err := os.Create(filename)
-	if err != nil {
+	filename := dirpath + "_meta.json"
+	// 重新留档时保留之前记录的音视频流
+	if err := writeMeta(filename, videoMeta{Arc: vinfo.Arc, Streams: readStreams(filename)}); err != nil {
 		log.Error().Err(err).Msgf("创建文件失败: %s", filename)
 		return
 	}
-	defer f.Close()
-	f.WriteString(string(jsonData))
 	// 下载封面
-	coverPath := filepath.Join(au.config.SavePath, dirpath+"_cover.jpg")
-	_, err = req.SetOutputFile(coverPath).Get(vinfo.Arc.Pic)
+	coverPath := dirpath + "_cover.jpg"
+	err = au.dm.FetchFile(vinfo.Arc.Pic, coverPath)
 	if err != nil {
 		log.Error().Err(err).Msgf("下载封面失败: %s", vinfo.Arc.Pic)
 	}
+	err = au.store.UpdateVideo(vinfo.Arc.Aid, func(rec *internal.VideoRecord) {
+		rec.Bvid = vinfo.Bvid
+		rec.Title = vinfo.Arc.Title
+		rec.AddSource(src.Key())
+		rec.PageCount = len(vinfo.Pages)
+		rec.Ctime = int(vinfo.Arc.Ctime)
+		rec.SetMetaPath(src.Key(), filename)
+		// 投稿出现在多个来源中时, 以第一个来源为准更新元数据
+		if rec.Source != "" && rec.Source != src.Key() && rec.MetaPath != "" {
+			return
+		}
+		rec.Source = src.Key()
+		rec.Mid = au.mid()
+		if src.Kind == sourceFav {
+			rec.FavID = int(src.ID)
+		}
+		rec.FavName = src.Name
+		rec.FavTime = favtime
+		rec.MetaPath = filename
+		rec.Deleted = false
+		rec.MetaUpdatedAt = int(time.Now().Unix())
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("记录投稿信息失败: %s", vinfo.Arc.Title)
+	}
 	log.Info().Msgf("保存投稿元数据完成: %s", vinfo.Arc.Title)
 }
 
@@ -0,0 +1,155 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+// 音频区歌曲, 下载后保存为带标签的音频文件
+// 留档状态数据库中以负数 auid 作为投稿 key, 避免与 avid 冲突
+
+// 是否为音频区歌曲
+func isAudio(media internal.FavMediaStruct) bool {
+	return media.Type == 12
+}
+
+func audioRecordID(sid int64) int64 {
+	return -sid
+}
+
+// 根据路径模板生成歌曲的保存路径(不含扩展名)
+func (au *ArchiverUser) audioPath(src videoSource, info *internal.AudioInfoStruct, favtime int) string {
+	artist := info.Author
+	if artist == "" {
+		artist = info.Uname
+	}
+	return au.fillPath(src, map[string]string{
+		"date":        internal.FormatDate(favtime),
+		"video_title": info.Title,
+		"bv":          fmt.Sprintf("au%d", info.ID),
+		"upper_name":  artist,
+		"pn":          "1",
+	})
+}
+
+func (au *ArchiverUser) archiveAudio(ctx context.Context, src videoSource, media internal.FavMediaStruct) error {
+	sid := media.ID
+	if rec, ok := au.store.GetVideo(audioRecordID(sid)); ok && au.hasSource(rec, src) {
+		if p := rec.GetPage(sid); p != nil && p.Archived() {
+			log.Debug().Msgf("音频已留档, 跳过: %s", media.Title)
+			return nil
+		}
+	}
+
+	log.Info().Msgf("开始处理音频: %s", media.Title)
+	info, err := au.bapi.GetAudioInfo(sid)
+	if err != nil {
+		log.Error().Err(err).Msgf("获取音频信息失败: %s", media.Title)
+		return err
+	}
+	dirpath := au.audioPath(src, &info, media.FavTime)
+	if au.pageArchived(audioRecordID(sid), 1, sid, dirpath) {
+		log.Debug().Msgf("音频已留档, 跳过: %s", media.Title)
+		return nil
+	}
+
+	tags := au.downloadAudioMeta(src, &info, dirpath, media.FavTime)
+
+	groupID := src.TaskGroupID(fmt.Sprintf("au%d", sid))
+	header := fmt.Sprintf("au%d-%s.%s", sid, info.Title, tags.Artist)
+	au.registerTaskGroup(ctx, src, groupID, info.Title, header, []internal.TaskGroupPage{{Cid: sid, Label: info.Title}})
+
+	urls, err := au.bapi.GetAudioURL(sid)
+	if ctx.Err() != nil {
+		return ctx.Err() // 程序退出, 下次启动时重新处理
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("获取音频下载地址失败: %s", info.Title)
+		err = fmt.Errorf("获取音频下载地址失败: %v", err)
+		au.dm.ReportTaskResult(groupID, sid, filepath.Dir(dirpath), err)
+		return err
+	}
+	downloaderTask := internal.DownloadTask{
+		GroupID:   groupID,
+		Mid:       au.mid(),
+		Aid:       audioRecordID(sid),
+		Cid:       sid,
+		AudioID:   sid,
+		Title:     fmt.Sprintf("[音频]%s", info.Title),
+		AudioUrls: urls,
+		DirPath:   dirpath,
+		Container: "m4a",
+		Duration:  info.Duration,
+		Tags:      tags,
+	}
+	stream := internal.StreamInfo{Description: "音频", Container: "m4a", Duration: info.Duration}
+	if au.dedupePage(&downloaderTask, fmt.Sprintf("au%d", sid), stream) {
+		return nil
+	}
+	if err := au.recordPendingPage(&downloaderTask, 1, stream); err != nil {
+		log.Error().Err(err).Msgf("记录音频信息失败: %s", info.Title)
+	}
+	au.dm.AddTask(&downloaderTask)
+	return nil
+}
+
+// 保存歌曲信息、封面和歌词, 返回写入音频文件的标签
+func (au *ArchiverUser) downloadAudioMeta(src videoSource, info *internal.AudioInfoStruct, dirpath string, favtime int) *internal.AudioTags {
+	tags := &internal.AudioTags{
+		Title:  info.Title,
+		Artist: info.Author,
+	}
+	if tags.Artist == "" {
+		tags.Artist = info.Uname
+	}
+	pdir := filepath.Dir(dirpath)
+	if err := os.MkdirAll(pdir, os.ModePerm); err != nil {
+		log.Error().Err(err).Msgf("创建目录失败: %s", pdir)
+		return tags
+	}
+	filename := dirpath + "_audio.json"
+	jsonData, _ := json.MarshalIndent(info, "", "  ")
+	if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+		log.Error().Err(err).Msgf("创建文件失败: %s", filename)
+	}
+	coverPath := dirpath + "_cover.jpg"
+	if err := au.dm.FetchFile(info.Cover, coverPath); err != nil {
+		log.Error().Err(err).Msgf("下载封面失败: %s", info.Cover)
+	} else {
+		tags.Cover = coverPath
+	}
+	if info.Lyric != "" {
+		lyrics, err := au.dm.Fetch(info.Lyric)
+		if err != nil {
+			log.Warn().Err(err).Msgf("下载歌词失败: %s", info.Title)
+		} else {
+			tags.Lyrics = string(lyrics)
+			os.WriteFile(dirpath+".lrc", lyrics, 0644)
+		}
+	}
+	err := au.store.UpdateVideo(audioRecordID(info.ID), func(rec *internal.VideoRecord) {
+		rec.Bvid = fmt.Sprintf("au%d", info.ID)
+		rec.Title = info.Title
+		rec.AddSource(src.Key())
+		rec.Source = src.Key()
+		rec.Mid = au.mid()
+		rec.FavName = src.Name
+		rec.FavTime = favtime
+		rec.Ctime = info.Passtime
+		rec.PageCount = 1
+		rec.MetaUpdatedAt = int(time.Now().Unix())
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("记录音频信息失败: %s", info.Title)
+	}
+	log.Info().Msgf("保存音频信息完成: %s", info.Title)
+	return tags
+}
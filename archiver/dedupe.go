@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+// 同一投稿出现在多个来源中时, 只下载一次, 其他来源中创建链接
+
+// 处理已在其他来源留档或正在下载的分P, 返回 true 时无需再下载
+func (au *ArchiverUser) dedupePage(task *internal.DownloadTask, bvid string, info internal.StreamInfo) bool {
+	key := info.ContentKey(bvid, task.Cid)
+	task.ContentKey = key
+	rec, ok := au.store.GetVideo(task.Aid)
+	if !ok {
+		return false
+	}
+	p := rec.GetPage(task.Cid)
+	if p == nil || p.Path == task.DirPath {
+		return false
+	}
+
+	if file := au.contentFile(key, p, bvid); file != "" {
+		out := task.OutPath()
+		err := internal.LinkFile(file, out, au.config.DedupeLink)
+		if err == nil {
+			err = au.store.UpdateVideo(task.Aid, func(rec *internal.VideoRecord) {
+				if p := rec.GetPage(task.Cid); p != nil {
+					p.AddLink(out)
+				}
+			})
+			if err != nil {
+				log.Error().Err(err).Msgf("记录链接文件失败: %s", task.Title)
+			}
+			log.Info().Msgf("已在其他来源留档, 创建链接: %s", task.Title)
+			au.dm.ReportTaskResult(task.GroupID, task.Cid, filepath.Dir(out), nil)
+			return true
+		}
+		log.Error().Err(err).Msgf("创建链接失败, 重新下载: %s", task.Title)
+	}
+
+	// 同一内容正在下载, 完成后再创建链接
+	if p.Status == internal.PageStatusPending && p.Stream.ContentKey(bvid, task.Cid) == key {
+		err := au.store.UpdateVideo(task.Aid, func(rec *internal.VideoRecord) {
+			if p := rec.GetPage(task.Cid); p != nil && !p.IsWaiting(task.DirPath) {
+				p.Waiting = append(p.Waiting, internal.PendingLink{Path: task.DirPath, GroupID: task.GroupID})
+			}
+		})
+		if err == nil {
+			log.Info().Msgf("已在其他来源下载中, 完成后创建链接: %s", task.Title)
+			return true
+		}
+		log.Error().Err(err).Msgf("记录等待链接失败: %s", task.Title)
+	}
+
+	// 其他来源下载失败时由当前来源接替, 否则以不同画质单独下载
+	task.Additional = p.Status != internal.PageStatusFailed
+	return false
+}
+
+// 获取已下载的内容文件, 内容索引中没有时使用分P记录中的文件
+func (au *ArchiverUser) contentFile(key string, p *internal.PageRecord, bvid string) string {
+	if cr, ok := au.store.GetContent(key); ok && cr.Exists() {
+		return cr.File
+	}
+	if p.Stream.ContentKey(bvid, p.Cid) == key && p.Archived() {
+		return p.File
+	}
+	return ""
+}
+
+// 记录已下载的分P, 单独下载的不同画质只记录为链接文件
+func (au *ArchiverUser) recordPendingPage(task *internal.DownloadTask, pn int, info internal.StreamInfo) error {
+	if task.Additional {
+		return nil
+	}
+	return au.store.UpdateVideo(task.Aid, func(rec *internal.VideoRecord) {
+		rec.SetPage(internal.PageRecord{
+			Cid:    task.Cid,
+			Page:   pn,
+			Path:   task.DirPath,
+			Status: internal.PageStatusPending,
+			Stream: info,
+		})
+	})
+}
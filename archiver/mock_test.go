@@ -0,0 +1,159 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+	"github.com/XiaoMiku01/bilibili-archiver/mock"
+)
+
+// 连接模拟服务器运行一轮收藏夹处理和下载管理器
+// 模拟 CDN 的音视频流为随机数据, 无法合并, 检查下载的临时文件、元数据和留档记录
+func TestArchiveFavoritesWithMockServer(t *testing.T) {
+	const streamSize = 64 << 10
+	server, err := mock.NewServer(mock.Options{Addr: "127.0.0.1:0", GRPCAddr: "127.0.0.1:0", Folders: 2, Videos: 2, StreamSize: streamSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	dir := t.TempDir()
+	cookiePath := filepath.Join(dir, "cookie.json")
+	if err := server.WriteCookieFile(cookiePath); err != nil {
+		t.Fatal(err)
+	}
+	ep := server.Endpoints()
+	yaml := fmt.Sprintf(`user: %s
+save_path: %s
+db_path: %s
+danmaku: true
+download_retry: 1
+rate_limit:
+  rate: 100
+  burst: 100
+endpoints:
+  api: %s
+  passport: %s
+  www: %s
+  grpc: %s
+`, cookiePath, filepath.Join(dir, "videos"), filepath.Join(dir, "archiver.db"), ep.API, ep.Passport, ep.WWW, ep.GRPC)
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := internal.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := internal.OpenArchiveStore(config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	limiter := config.NewRateLimiter()
+	dm := internal.NewDownloaderManager(ctx, config, limiter, store)
+	bapi := internal.NewBApiClient(ctx, config, limiter)
+	au := NewArchiverUser(*config, bapi, dm, store, true)
+	if err := au.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bapi.InitGRPC(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bapi.CloseGRPC() })
+
+	// 处理完所有收藏夹后再启动下载, 第二个收藏夹中的重复投稿确定进入等待链接
+	au.archiveFavorites(ctx, true, 0)
+	dmDone := make(chan struct{})
+	go func() {
+		dm.Run()
+		close(dmDone)
+	}()
+	defer func() {
+		cancel()
+		<-dmDone
+	}()
+
+	// 等待所有任务超过重试次数
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		tasks, err := store.ListTasks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pending := 0
+		for _, qt := range tasks {
+			if qt.Status != internal.TaskStatusFailed {
+				pending++
+			}
+		}
+		if len(tasks) == 4 && pending == 0 {
+			for _, qt := range tasks {
+				if !strings.Contains(qt.LastError, "合并失败") {
+					t.Errorf("任务 %s 失败原因 = %q, 预期合并失败", qt.Task.Title, qt.LastError)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待下载任务超时: %d 个任务, %d 个未完成", len(tasks), pending)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for i, fid := range []int64{1000, 1001} {
+		src := videoSource{Mid: au.mid(), Kind: sourceFav, ID: fid}
+		if _, ok := store.GetCheckpoint(src.Key()); !ok {
+			t.Errorf("收藏夹 %d 没有保存断点", i+1)
+		}
+	}
+
+	for aid := int64(100001); aid <= 100004; aid++ {
+		rec, ok := store.GetVideo(aid)
+		if !ok {
+			t.Fatalf("没有投稿 %d 的留档记录", aid)
+		}
+		if _, err := os.Stat(rec.MetaPath); err != nil {
+			t.Errorf("投稿 %d 元数据: %v", aid, err)
+		}
+		p := rec.GetPage(aid*10 + 1)
+		if p == nil {
+			t.Fatalf("投稿 %d 没有分P记录", aid)
+		}
+		if p.Status != internal.PageStatusFailed {
+			t.Errorf("投稿 %d 分P状态 = %s, 预期 %s", aid, p.Status, internal.PageStatusFailed)
+		}
+		if len(p.Waiting) != 0 {
+			t.Errorf("投稿 %d 下载失败后仍在等待链接: %v", aid, p.Waiting)
+		}
+		for _, file := range []string{p.Path + "_cover.jpg", p.Path + "_danmaku.xml"} {
+			if _, err := os.Stat(file); err != nil {
+				t.Errorf("投稿 %d: %v", aid, err)
+			}
+		}
+		// 合并失败时保留临时文件, 重试时断点续传
+		for _, file := range []string{p.Path + ".mp4.1", p.Path + ".mp3.1"} {
+			fi, err := os.Stat(file)
+			if err != nil {
+				t.Errorf("投稿 %d: %v", aid, err)
+			} else if fi.Size() != streamSize {
+				t.Errorf("%s 大小 = %d, 预期 %d", file, fi.Size(), streamSize)
+			}
+		}
+	}
+
+	// 两个收藏夹中的同一投稿只下载一次
+	rec, _ := store.GetVideo(100001)
+	if len(rec.Sources) != 2 {
+		t.Errorf("投稿 100001 的来源 = %v, 预期两个收藏夹", rec.Sources)
+	}
+}
@@ -0,0 +1,260 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+// 番剧/影视等 PGC 内容和课程, 每一集作为一个分P留档
+
+// 是否为需要留档的 PGC 内容
+func (au *ArchiverUser) isPGC(media internal.FavMediaStruct) bool {
+	return !au.config.SkipPGC && media.Ugc.FirstCid == 0 && media.Ogv != nil && media.Ogv.SeasonID != 0
+}
+
+// 课程链接中的 ss/ep 编号, 如 https://www.bilibili.com/cheese/play/ss123
+var courseLinkRe = regexp.MustCompile(`/cheese/play/(ss|ep)(\d+)`)
+
+// 解析收藏中的课程, 返回课程 season_id 或 ep_id
+func (au *ArchiverUser) courseID(media internal.FavMediaStruct) (seasonID, epID int64, ok bool) {
+	if au.config.SkipPGC || media.Ugc.FirstCid != 0 {
+		return 0, 0, false
+	}
+	m := courseLinkRe.FindStringSubmatch(media.Link)
+	if m == nil {
+		return 0, 0, false
+	}
+	id, _ := strconv.ParseInt(m[2], 10, 64)
+	if m[1] == "ss" {
+		return id, 0, true
+	}
+	return 0, id, true
+}
+
+// 是否为需要留档的课程
+func (au *ArchiverUser) isCourse(media internal.FavMediaStruct) bool {
+	_, _, ok := au.courseID(media)
+	return ok
+}
+
+// 根据路径模板生成单集的保存路径(不含扩展名)
+func (au *ArchiverUser) episodePath(src videoSource, season *internal.PGCSeasonStruct, i, favtime int) string {
+	ep := season.Episodes[i]
+	return au.fillPath(src, map[string]string{
+		"date":         internal.FormatDate(favtime),
+		"video_title":  season.Title,
+		"bv":           fmt.Sprintf("ss%d", season.SeasonID),
+		"upper_name":   season.TypeName(),
+		"pn":           fmt.Sprintf("%d", i+1),
+		"season_title": season.Title,
+		"season_id":    strconv.FormatInt(season.SeasonID, 10),
+		"ep_id":        strconv.FormatInt(ep.ID, 10),
+		"ep_index":     ep.Title,
+		"ep_title":     ep.LongTitle,
+	})
+}
+
+// 单集名称, 用于日志和通知
+func episodeLabel(ep internal.PGCEpisodeStruct) string {
+	if ep.LongTitle == "" {
+		return "第" + ep.Title + "集"
+	}
+	return fmt.Sprintf("第%s集 %s", ep.Title, ep.LongTitle)
+}
+
+// 留档 PGC 内容, 收藏的是单集时只留档该集, 否则留档整个剧集
+func (au *ArchiverUser) archivePGC(ctx context.Context, src videoSource, media internal.FavMediaStruct) error {
+	log.Info().Msgf("开始处理%s: %s", media.Ogv.TypeName, media.Title)
+	season, err := au.bapi.GetPGCSeason(media.Ogv.SeasonID)
+	if err != nil {
+		log.Error().Err(err).Msgf("获取剧集信息失败: %s", media.Title)
+		return err
+	}
+	return au.archiveSeason(ctx, src, media, &season, media.ID)
+}
+
+// 留档课程, 收藏的是单集时只留档该集, 否则留档整个课程
+// 未购买的付费课程获取播放地址失败, 按失败次数上限跳过
+func (au *ArchiverUser) archiveCourse(ctx context.Context, src videoSource, media internal.FavMediaStruct) error {
+	log.Info().Msgf("开始处理课程: %s", media.Title)
+	seasonID, epID, _ := au.courseID(media)
+	season, err := au.bapi.GetCourseSeason(seasonID, epID)
+	if err != nil {
+		log.Error().Err(err).Msgf("获取课程信息失败: %s", media.Title)
+		return err
+	}
+	if epID == 0 {
+		epID = -1 // 收藏的是整个课程
+	}
+	return au.archiveSeason(ctx, src, media, &season, epID)
+}
+
+// 留档剧集中 ID 或 avid 为 selectID 的单集, 没有匹配时留档所有单集
+func (au *ArchiverUser) archiveSeason(ctx context.Context, src videoSource, media internal.FavMediaStruct, season *internal.PGCSeasonStruct, selectID int64) error {
+	if len(season.Episodes) == 0 {
+		log.Warn().Msgf("剧集没有可下载的内容: %s", media.Title)
+		return nil
+	}
+
+	selected := make([]int, 0, len(season.Episodes))
+	for i, ep := range season.Episodes {
+		if ep.ID == selectID || ep.Aid == selectID {
+			selected = []int{i}
+			break
+		}
+		selected = append(selected, i)
+	}
+
+	var pending []int
+	for _, i := range selected {
+		ep := season.Episodes[i]
+		if !au.pageArchived(ep.Aid, 1, ep.Cid, au.episodePath(src, season, i, media.FavTime)) {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		log.Debug().Msgf("剧集已留档, 跳过: %s", media.Title)
+		return nil
+	}
+
+	au.downloadSeasonMeta(src, season, pending, media.FavTime)
+	return au.downloadEpisodes(ctx, src, season, pending, media.FavTime)
+}
+
+// 保存剧集信息和封面, PGC 不参与元数据更新
+func (au *ArchiverUser) downloadSeasonMeta(src videoSource, season *internal.PGCSeasonStruct, pending []int, favtime int) {
+	dirpath := au.episodePath(src, season, pending[0], favtime)
+	pdir := filepath.Dir(dirpath)
+	err := os.MkdirAll(pdir, os.ModePerm)
+	if err != nil {
+		log.Error().Err(err).Msgf("创建目录失败: %s", pdir)
+		return
+	}
+	filename := dirpath + "_season.json"
+	if err := writeMeta(filename, seasonMeta{PGCSeasonStruct: season, Streams: readStreams(filename)}); err != nil {
+		log.Error().Err(err).Msgf("创建文件失败: %s", filename)
+		return
+	}
+	coverPath := dirpath + "_cover.jpg"
+	err = au.dm.FetchFile(season.Cover, coverPath)
+	if err != nil {
+		log.Error().Err(err).Msgf("下载封面失败: %s", season.Cover)
+	}
+	for _, i := range pending {
+		ep := season.Episodes[i]
+		err = au.store.UpdateVideo(ep.Aid, func(rec *internal.VideoRecord) {
+			rec.Bvid = ep.Bvid
+			rec.Title = fmt.Sprintf("%s %s", season.Title, episodeLabel(ep))
+			rec.AddSource(src.Key())
+			rec.Source = src.Key()
+			rec.Mid = au.mid()
+			rec.FavName = src.Name
+			rec.FavTime = favtime
+			rec.Ctime = ep.PubTime
+			rec.PageCount = 1
+			rec.MetaUpdatedAt = int(time.Now().Unix())
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("记录剧集信息失败: %s", season.Title)
+		}
+	}
+	log.Info().Msgf("保存剧集信息完成: %s", season.Title)
+}
+
+// 添加单集下载任务, 获取播放地址失败时返回最后一个错误
+func (au *ArchiverUser) downloadEpisodes(ctx context.Context, src videoSource, season *internal.PGCSeasonStruct, pending []int, favtime int) error {
+	groupID := src.TaskGroupID(fmt.Sprintf("ss%d", season.SeasonID))
+	var lastErr error
+	var groupPages []internal.TaskGroupPage
+	for _, i := range pending {
+		ep := season.Episodes[i]
+		groupPages = append(groupPages, internal.TaskGroupPage{Cid: ep.Cid, Label: episodeLabel(ep)})
+	}
+	header := fmt.Sprintf("ss%d-%s.%s (%d集)", season.SeasonID, season.Title, season.TypeName(), len(season.Episodes))
+	au.registerTaskGroup(ctx, src, groupID, season.Title, header, groupPages)
+	metaPath := au.episodePath(src, season, pending[0], favtime) + "_season.json"
+	streams := readStreams(metaPath)
+	defer func() {
+		if err := writeMeta(metaPath, seasonMeta{PGCSeasonStruct: season, Streams: streams}); err != nil {
+			log.Error().Err(err).Msgf("记录音视频流失败: %s", metaPath)
+		}
+	}()
+
+	for _, i := range pending {
+		ep := season.Episodes[i]
+		label := fmt.Sprintf("%s %s", season.Title, episodeLabel(ep))
+		log.Info().Msgf("剧集信息: %s: ep%d: cid: %d", groupID, ep.ID, ep.Cid)
+		dirpath := au.episodePath(src, season, i, favtime)
+		policy := src.Settings.Policy
+		var playInfo internal.PlayInfoStruct
+		var err error
+		if season.Course {
+			playInfo, err = au.bapi.GetCoursePlayURL(ep.Aid, ep.Cid, ep.ID, policy.Fnval())
+		} else {
+			playInfo, err = au.bapi.GetPGCPlayURL(ep.Aid, ep.Cid, ep.ID, policy.Fnval())
+		}
+		if ctx.Err() != nil {
+			return ctx.Err() // 程序退出, 下次启动时重新处理
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("获取剧集播放信息失败: %s", label)
+			lastErr = fmt.Errorf("获取播放信息失败: %v", err)
+			au.dm.ReportTaskResult(groupID, ep.Cid, filepath.Dir(dirpath), lastErr)
+			continue
+		}
+		sel, err := playInfo.SelectStreams(policy)
+		if err != nil {
+			log.Error().Err(err).Msgf("选择音视频流失败: %s", label)
+			lastErr = err
+			au.dm.ReportTaskResult(groupID, ep.Cid, filepath.Dir(dirpath), err)
+			continue
+		}
+		log.Debug().Msgf("选择音视频流: %s: %s %s %dx%d", label, sel.Info.Description, sel.Info.VideoCodecs, sel.Info.Width, sel.Info.Height)
+		streams[episodeLabel(ep)] = sel.Info
+
+		downloaderTask := internal.DownloadTask{
+			GroupID:   groupID,
+			Mid:       au.mid(),
+			Aid:       ep.Aid,
+			Cid:       ep.Cid,
+			EpID:      ep.ID,
+			Course:    season.Course,
+			Title:     fmt.Sprintf("[%s]%s", sel.Info.Description, label),
+			VideoUrls: sel.Video,
+			AudioUrls: sel.Audio,
+			DirPath:   dirpath,
+			Policy:    policy,
+			Container: sel.Info.Container,
+			Duration:  sel.Info.Duration,
+		}
+		if policy.AudioOnly {
+			downloaderTask.Tags = &internal.AudioTags{
+				Title:  episodeLabel(ep),
+				Artist: season.TypeName(),
+				Album:  season.Title,
+				Cover:  au.episodePath(src, season, pending[0], favtime) + "_cover.jpg",
+			}
+		}
+		// 下载弹幕
+		if src.Settings.Danmaku {
+			au.saveDanmaku(ep.Cid, dirpath, label)
+		}
+		if au.dedupePage(&downloaderTask, ep.Bvid, sel.Info) {
+			continue
+		}
+		if err := au.recordPendingPage(&downloaderTask, 1, sel.Info); err != nil {
+			log.Error().Err(err).Msgf("记录分P信息失败: %s", label)
+		}
+		au.dm.AddTask(&downloaderTask)
+	}
+	return lastErr
+}
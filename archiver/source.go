@@ -0,0 +1,678 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+const (
+	sourceFav     = "fav"     // 收藏夹
+	sourceSeason  = "season"  // 合集
+	sourceUpper   = "upper"   // UP主投稿
+	sourceToView  = "toview"  // 稍后再看
+	sourceLiked   = "liked"   // 点赞的视频
+	sourceCoined  = "coined"  // 投币的视频
+	sourceHistory = "history" // 历史记录
+)
+
+// 投稿来源
+type videoSource struct {
+	Mid       int64 // 账号 mid, 多个账号的同一来源互不影响
+	Kind      string
+	ID        int64
+	Name      string // 来源名称, 对应路径模板中的 {{ source }}
+	Unordered bool   // 投稿不按时间倒序排列 (如合集), 需要遍历全部投稿
+	Untimed   bool   // 投稿没有加入时间 (如点赞), 断点只记录最新投稿
+	Template  string // 来源默认的存储路径模板, 为空时使用 path_template
+
+	Settings internal.FolderSettings // 按收藏夹规则解析后生效的设置
+}
+
+// 增量同步断点和留档记录中来源的 key, 格式为 <账号mid>:<类型>:<ID>
+func (src videoSource) Key() string {
+	return fmt.Sprintf("%d:%s:%d", src.Mid, src.Kind, src.ID)
+}
+
+// 支持多账号之前的 key, 收藏夹为收藏夹ID, 其他来源为 <类型>:<ID>
+// 只用于第一个账号读取旧的断点和留档记录
+func (src videoSource) legacyKey() string {
+	if src.Kind == sourceFav {
+		return strconv.FormatInt(src.ID, 10)
+	}
+	return fmt.Sprintf("%s:%d", src.Kind, src.ID)
+}
+
+// 任务组ID, 同一投稿在不同账号和来源中的任务组互不影响
+func (src videoSource) TaskGroupID(id string) string {
+	return id + "@" + src.Key()
+}
+
+// 投稿是否属于当前账号的该来源, 旧记录只属于第一个账号
+func (au *ArchiverUser) hasSource(rec internal.VideoRecord, src videoSource) bool {
+	if rec.Source == "" && len(rec.Sources) == 0 {
+		return au.primary
+	}
+	return rec.HasSource(src.Key()) || (au.primary && rec.HasSource(src.legacyKey()))
+}
+
+// 根据留档记录的来源解析生效的设置, 旧记录没有来源时按收藏夹处理
+func (au *ArchiverUser) recordSettings(rec internal.VideoRecord) internal.FolderSettings {
+	return au.sourceSettings(rec.Source, rec.FavName, rec.FavID)
+}
+
+// 根据来源 key 和名称解析生效的设置, key 为空时使用 favID 按收藏夹处理
+func (au *ArchiverUser) sourceSettings(key, name string, favID int) internal.FolderSettings {
+	kind, id := sourceFav, int64(favID)
+	parts := strings.Split(key, ":")
+	switch len(parts) {
+	case 1: // 旧记录的收藏夹ID
+		if key != "" {
+			id, _ = strconv.ParseInt(key, 10, 64)
+		}
+	case 2: // 旧记录的 <类型>:<ID>
+		kind = parts[0]
+		id, _ = strconv.ParseInt(parts[1], 10, 64)
+	default:
+		kind = parts[1]
+		id, _ = strconv.ParseInt(parts[2], 10, 64)
+	}
+	if kind == sourceToView {
+		return au.config.FolderSettings(kind, id, name, au.config.ToViewPathTemplate)
+	}
+	return au.config.FolderSettings(kind, id, name, "")
+}
+
+// 分页获取来源中的投稿, 投稿按收藏/发布时间倒序排列
+type mediaPager func(pn int) (medias []internal.FavMediaStruct, hasMore bool, err error)
+
+// 处理所有收藏夹
+func (au *ArchiverUser) archiveFavorites(ctx context.Context, isFull bool, lastRoundTime int) {
+	favs, err := au.bapi.GetFavList(au.buser.Mid)
+	if err != nil {
+		log.Error().Err(err).Msg("获取收藏夹列表失败")
+		return
+	}
+	// 过滤收藏夹
+	favs = au.FillerFavoriteList(favs)
+	log.Info().Msgf("过滤后收藏夹数量: %d , 过滤关键词: %v ", len(favs.List), au.config.Keywords)
+	for _, fav := range favs.List {
+		log.Info().Msgf("开始处理收藏夹: %s", fav.Title)
+		src := videoSource{Kind: sourceFav, ID: int64(fav.ID), Name: fav.Title}
+		au.archiveSource(ctx, src, au.favPager(fav.ID), isFull, lastRoundTime)
+		log.Debug().Msgf("收藏夹: %s 处理完成", fav.Title)
+	}
+}
+
+// 处理收藏的收藏夹和合集
+func (au *ArchiverUser) archiveCollected(ctx context.Context, isFull bool, lastRoundTime int) {
+	var collected internal.FavListStruct
+	for pn := 1; ; pn++ {
+		list, err := au.bapi.GetCollectedFavList(au.buser.Mid, pn)
+		if err != nil {
+			log.Error().Err(err).Msg("获取收藏的收藏夹和合集失败")
+			return
+		}
+		collected.List = append(collected.List, list.List...)
+		if !list.HasMore || len(list.List) == 0 {
+			break
+		}
+	}
+	collected = au.FillerFavoriteList(collected)
+	log.Info().Msgf("过滤后收藏的收藏夹和合集数量: %d , 过滤关键词: %v ", len(collected.List), au.config.Keywords)
+	for _, fav := range collected.List {
+		switch fav.Type {
+		case 11: // 收藏夹
+			log.Info().Msgf("开始处理收藏的收藏夹: %s", fav.Title)
+			src := videoSource{Kind: sourceFav, ID: int64(fav.ID), Name: fav.Title}
+			au.archiveSource(ctx, src, au.favPager(fav.ID), isFull, lastRoundTime)
+		case 21: // 合集
+			log.Info().Msgf("开始处理合集: %s", fav.Title)
+			src := videoSource{Kind: sourceSeason, ID: int64(fav.ID), Name: "合集-" + fav.Title, Unordered: true}
+			au.archiveSource(ctx, src, au.seasonPager(fav.ID), isFull, lastRoundTime)
+		default:
+			log.Warn().Msgf("不支持的收藏类型: %s [type: %d]", fav.Title, fav.Type)
+			continue
+		}
+		log.Debug().Msgf("%s 处理完成", fav.Title)
+	}
+}
+
+func (au *ArchiverUser) seasonPager(seasonID int) mediaPager {
+	return func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		list, err := au.bapi.GetSeasonMediaList(seasonID, pn)
+		if err != nil {
+			return nil, false, err
+		}
+		medias := list.Medias
+		for i := range medias {
+			medias[i].FavTime = medias[i].Pubtime // 合集投稿以发布时间作为断点和路径中的日期
+		}
+		return medias, pn*20 < list.Info.MediaCount, nil
+	}
+}
+
+func (au *ArchiverUser) favPager(fid int) mediaPager {
+	return func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		favMediaList, err := au.bapi.GetFavMediaList(fid, pn)
+		if err != nil {
+			return nil, false, err
+		}
+		var medias []internal.FavMediaStruct
+		for _, media := range favMediaList.Medias {
+			if media.Ugc.FirstCid == 0 && !au.isPGC(media) && !au.isCourse(media) && !isAudio(media) {
+				log.Debug().Msgf("失效或不支持的收藏, 跳过: %s (%s)", media.Title, media.Link)
+				continue
+			}
+			medias = append(medias, media)
+		}
+		return medias, favMediaList.HasMore, nil
+	}
+}
+
+// 处理所有监控的UP主
+func (au *ArchiverUser) archiveUppers(ctx context.Context, isFull bool, lastRoundTime int) {
+	for _, mid := range au.config.Uppers {
+		card, err := au.bapi.GetUserCard(mid)
+		if err != nil {
+			log.Error().Err(err).Msgf("获取UP主信息失败: %d", mid)
+			continue
+		}
+		src := videoSource{Kind: sourceUpper, ID: int64(mid), Name: "UP主-" + card.Card.Name}
+		if !au.config.FolderEnabled(src.Kind, src.ID, src.Name) {
+			log.Debug().Msgf("%s 被收藏夹规则排除, 跳过", src.Name)
+			continue
+		}
+		log.Info().Msgf("开始处理UP主: %s [UID: %d]", card.Card.Name, mid)
+		au.archiveSource(ctx, src, au.upperPager(mid), isFull, lastRoundTime)
+		log.Debug().Msgf("UP主: %s 处理完成", card.Card.Name)
+	}
+}
+
+func (au *ArchiverUser) upperPager(mid int) mediaPager {
+	return func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		list, err := au.bapi.GetSpaceVideoList(mid, pn)
+		if err != nil {
+			return nil, false, err
+		}
+		var medias []internal.FavMediaStruct
+		for _, v := range list.List.Vlist {
+			media := internal.FavMediaStruct{
+				ID:       v.Aid,
+				Type:     2,
+				Title:    v.Title,
+				Duration: internal.ParseLength(v.Length),
+				Bvid:     v.Bvid,
+				Ctime:    v.Created,
+				Pubtime:  v.Created,
+				FavTime:  v.Created, // UP主投稿以发布时间作为断点和路径中的日期
+			}
+			media.Upper.Mid = int(v.Mid)
+			media.Upper.Name = v.Author
+			medias = append(medias, media)
+		}
+		return medias, list.Page.Pn*list.Page.Ps < list.Page.Count, nil
+	}
+}
+
+// 处理稍后再看
+func (au *ArchiverUser) archiveToView(ctx context.Context, isFull bool, lastRoundTime int) {
+	src := videoSource{
+		Kind:     sourceToView,
+		ID:       int64(au.buser.Mid),
+		Name:     "稍后再看",
+		Template: au.config.ToViewPathTemplate,
+	}
+	if !au.config.FolderEnabled(src.Kind, src.ID, src.Name) {
+		log.Debug().Msgf("%s 被收藏夹规则排除, 跳过", src.Name)
+		return
+	}
+	log.Info().Msg("开始处理稍后再看")
+	au.archiveSource(ctx, src, au.toViewPager(), isFull, lastRoundTime)
+	log.Debug().Msg("稍后再看处理完成")
+}
+
+// 稍后再看没有分页, 只返回加入时间超过 toview_min_hours 的投稿
+// 未满足时间的投稿不会推进断点, 之后满足时间时仍会被处理
+func (au *ArchiverUser) toViewPager() mediaPager {
+	return func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		if pn > 1 {
+			return nil, false, nil
+		}
+		list, err := au.bapi.GetToViewList()
+		if err != nil {
+			return nil, false, err
+		}
+		deadline := int(time.Now().Add(-time.Duration(au.config.ToViewMinHours) * time.Hour).Unix())
+		var medias []internal.FavMediaStruct
+		for _, v := range list.List {
+			if v.AddAt > deadline {
+				continue
+			}
+			media := internal.FavMediaStruct{
+				ID:       v.Aid,
+				Type:     2,
+				Title:    v.Title,
+				Page:     v.Videos,
+				Duration: v.Duration,
+				Bvid:     v.Bvid,
+				Pubtime:  v.Pubdate,
+				FavTime:  v.AddAt,
+			}
+			media.Upper.Mid = int(v.Owner.Mid)
+			media.Upper.Name = v.Owner.Name
+			media.Ugc.FirstCid = v.Cid
+			medias = append(medias, media)
+		}
+		sort.SliceStable(medias, func(i, j int) bool { return medias[i].FavTime > medias[j].FavTime })
+		return medias, false, nil
+	}
+}
+
+// 处理点赞、投币和历史记录, 由各自的开关启用, 可以用 kind 规则排除或单独设置
+func (au *ArchiverUser) archiveVirtualFolders(ctx context.Context, isFull bool, lastRoundTime int) {
+	mid := int64(au.buser.Mid)
+	folders := []struct {
+		enabled bool
+		src     videoSource
+		fetch   mediaPager
+	}{
+		{au.config.Liked, videoSource{Kind: sourceLiked, ID: mid, Name: "点赞的视频", Untimed: true}, au.arcListPager(au.bapi.GetLikedVideoList)},
+		{au.config.Coined, videoSource{Kind: sourceCoined, ID: mid, Name: "投币的视频", Untimed: true}, au.arcListPager(au.bapi.GetCoinedVideoList)},
+		{au.config.History, videoSource{Kind: sourceHistory, ID: mid, Name: "历史记录"}, au.historyPager()},
+	}
+	for _, f := range folders {
+		if !f.enabled {
+			continue
+		}
+		if !au.config.FolderEnabled(f.src.Kind, f.src.ID, f.src.Name) {
+			log.Debug().Msgf("%s 被收藏夹规则排除, 跳过", f.src.Name)
+			continue
+		}
+		log.Info().Msgf("开始处理: %s", f.src.Name)
+		au.archiveSource(ctx, f.src, f.fetch, isFull, lastRoundTime)
+		log.Debug().Msgf("%s 处理完成", f.src.Name)
+	}
+}
+
+// 点赞和投币列表没有分页, 路径中的日期使用发布时间
+func (au *ArchiverUser) arcListPager(list func(mid int) ([]internal.ArcStruct, error)) mediaPager {
+	return func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		if pn > 1 {
+			return nil, false, nil
+		}
+		arcs, err := list(au.buser.Mid)
+		if err != nil {
+			return nil, false, err
+		}
+		var medias []internal.FavMediaStruct
+		for _, arc := range arcs {
+			medias = append(medias, arc.FavMedia(arc.Pubdate))
+		}
+		return medias, false, nil
+	}
+}
+
+// 历史记录按游标分页, 只返回 history_days 天内观看的投稿
+func (au *ArchiverUser) historyPager() mediaPager {
+	var cursorMax int64
+	var cursorViewAt int
+	return func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		if pn == 1 {
+			cursorMax, cursorViewAt = 0, 0
+		}
+		history, err := au.bapi.GetHistory(cursorMax, cursorViewAt)
+		if err != nil {
+			return nil, false, err
+		}
+		cursorMax, cursorViewAt = history.Cursor.Max, history.Cursor.ViewAt
+		deadline := int(time.Now().AddDate(0, 0, -au.config.HistoryDays).Unix())
+		var medias []internal.FavMediaStruct
+		for _, item := range history.List {
+			if item.ViewAt < deadline {
+				return medias, false, nil
+			}
+			if item.History.Business != "archive" {
+				continue
+			}
+			media := internal.FavMediaStruct{
+				ID:       item.History.Oid,
+				Type:     2,
+				Title:    item.Title,
+				Page:     item.Videos,
+				Duration: item.Duration,
+				Bvid:     item.History.Bvid,
+				FavTime:  item.ViewAt,
+			}
+			media.Upper.Mid = int(item.AuthorMid)
+			media.Upper.Name = item.AuthorName
+			media.Ugc.FirstCid = item.History.Cid
+			medias = append(medias, media)
+		}
+		return medias, len(history.List) != 0 && cursorMax != 0, nil
+	}
+}
+
+// 获取投稿列表失败时的最大重试次数, 超过后放弃本轮处理, 断点保持不变
+const maxPageRetries = 3
+
+// 投稿连续处理失败的最大次数, 超过后记录为失败不再处理, 断点不再停留在该投稿之前
+const maxMediaFailures = 3
+
+// 按增量同步断点处理一个来源中的投稿, 处理完成后保存断点
+// 断点只推进到处理成功的投稿, 处理失败的投稿在之后的轮次中重新处理
+func (au *ArchiverUser) archiveSource(ctx context.Context, src videoSource, fetch mediaPager, isFull bool, lastRoundTime int) {
+	src.Mid = au.mid()
+	src.Settings = au.config.FolderSettings(src.Kind, src.ID, src.Name, src.Template)
+	// 读取增量同步断点, 没有断点的来源以上一轮结束时间为准
+	// 第一个账号沿用支持多账号之前的断点, 处理完成后保存到新的 key
+	cp, ok := au.store.GetCheckpoint(src.Key())
+	if !ok && au.primary {
+		cp, ok = au.store.GetCheckpoint(src.legacyKey())
+	}
+	if !ok {
+		cp = internal.Checkpoint{FavTime: lastRoundTime}
+	}
+	var newest internal.Checkpoint       // 本轮处理到的最新投稿
+	var failed *internal.Checkpoint      // 处理失败的最早投稿
+	var afterFailed *internal.Checkpoint // 有序来源中早于所有失败投稿的最新成功投稿
+	var succeeded []internal.Checkpoint  // 无序来源中处理成功的投稿
+	var seen []int64                     // 按列表顺序获取到的投稿, 用于生成备用锚点
+
+	// 判断投稿是否已在断点之前处理过
+	reached := func(media internal.FavMediaStruct) bool {
+		if isFull {
+			return false
+		}
+		if src.Untimed {
+			// 没有加入时间的来源只记录最新投稿, 首次处理时不下载已有投稿
+			// 断点投稿被取消点赞后, 以之后的投稿作为备用锚点
+			return !ok || media.ID == cp.MediaID || slices.Contains(cp.Anchors, media.ID)
+		}
+		return cp.Reached(media.FavTime, media.ID)
+	}
+
+	retries := 0
+	for pn := 1; ; pn++ {
+		if ctx.Err() != nil {
+			return // 程序退出时不保存断点, 下次启动时重新处理
+		}
+		medias, hasMore, err := fetch(pn)
+		if err != nil {
+			log.Error().Err(err).Msgf("获取投稿列表: %s pn:%d 失败", src.Name, pn)
+			if retries++; retries > maxPageRetries {
+				log.Error().Msgf("获取投稿列表: %s pn:%d 连续失败 %d 次, 放弃本轮处理", src.Name, pn, retries)
+				return
+			}
+			pn--
+			internal.SleepContext(ctx, 10*time.Second)
+			continue
+		}
+		retries = 0
+		for _, media := range medias {
+			seen = append(seen, media.ID)
+		}
+
+		if len(medias) == 0 {
+			if pn == 1 && !hasMore {
+				log.Warn().Msgf("%s pn:%d 无投稿", src.Name, pn)
+			}
+		} else if src.Unordered {
+			for _, media := range medias {
+				if media.FavTime > newest.FavTime {
+					newest = internal.Checkpoint{FavTime: media.FavTime, MediaID: media.ID}
+				}
+			}
+		} else {
+			if newest.MediaID == 0 {
+				newest = internal.Checkpoint{
+					FavTime: medias[0].FavTime,
+					MediaID: medias[0].ID,
+				}
+			}
+			// 如果不是全量处理，且最近的稿件已在断点之前，跳出循环
+			if reached(medias[0]) {
+				log.Debug().Msgf("上一次处理时间: %s, 最近稿件时间: %s", internal.FormatTime(cp.FavTime), internal.FormatTime(medias[0].FavTime))
+				break
+			}
+		}
+
+		done := false
+		for _, media := range medias {
+			if reached(media) {
+				if src.Unordered {
+					continue // 无序来源只跳过断点之前的投稿
+				}
+				log.Debug().Msgf("上一次处理时间: %s, 当前稿件时间: %s, 退出遍历", internal.FormatTime(cp.FavTime), internal.FormatTime(media.FavTime))
+				done = true
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			err := au.tryArchiveMedia(ctx, src, media)
+			if ctx.Err() != nil {
+				return
+			}
+			c := internal.Checkpoint{FavTime: media.FavTime, MediaID: media.ID}
+			switch {
+			case err != nil && src.Unordered:
+				if failed == nil || c.FavTime < failed.FavTime {
+					failed = &c
+				}
+			case err != nil:
+				failed, afterFailed = &c, nil // 有序来源按时间倒序处理, 最后失败的投稿最早
+			case src.Unordered:
+				succeeded = append(succeeded, c)
+			case failed != nil && afterFailed == nil:
+				afterFailed = &c
+			}
+		}
+		// 获取分页 time.sleep
+		log.Debug().Msgf("%s pn:%d 处理完成", src.Name, pn)
+		if done || !hasMore {
+			break
+		}
+	}
+	// 来源处理完成 保存断点
+	next, save := newest, newest.MediaID != 0
+	// 首次处理时没有符合条件的投稿也保存起始断点, 避免断点随每轮结束时间推移而漏掉延迟出现的投稿
+	if !save && !ok {
+		next, save = cp, true
+	}
+	if failed != nil {
+		// 断点退回到失败的投稿之前, 全量处理时原断点可能晚于失败的投稿, 从头开始
+		next, save = cp, true
+		if isFull {
+			next = internal.Checkpoint{}
+		}
+		if afterFailed != nil {
+			next = *afterFailed
+		}
+		for _, c := range succeeded {
+			if c.FavTime < failed.FavTime && c.FavTime > next.FavTime {
+				next = c
+			}
+		}
+		log.Warn().Msgf("%s 有投稿处理失败, 断点停留在 %s, 之后的轮次重新处理", src.Name, internal.FormatTime(next.FavTime))
+	}
+	if src.Untimed && next.MediaID != 0 {
+		next.Anchors = untimedAnchors(seen, next, cp)
+	}
+	if save {
+		if err := au.store.SaveCheckpoint(src.Key(), next); err != nil {
+			log.Error().Err(err).Msgf("保存增量同步断点失败: %s", src.Name)
+		}
+	}
+}
+
+// 没有加入时间的来源保存的备用锚点数量
+const maxAnchors = 20
+
+// 生成断点的备用锚点: 本轮列表中断点及之后的投稿, 不足时补充上一次的锚点
+func untimedAnchors(seen []int64, next, prev internal.Checkpoint) []int64 {
+	i := slices.Index(seen, next.MediaID)
+	if i < 0 {
+		return next.Anchors // 断点未推进
+	}
+	anchors := slices.Clone(seen[i:min(len(seen), i+maxAnchors)])
+	for _, id := range append([]int64{prev.MediaID}, prev.Anchors...) {
+		if len(anchors) >= maxAnchors {
+			break
+		}
+		if id != 0 && !slices.Contains(anchors, id) {
+			anchors = append(anchors, id)
+		}
+	}
+	return anchors
+}
+
+// 处理投稿并记录失败次数, 连续失败达到 maxMediaFailures 次后不再返回错误
+func (au *ArchiverUser) tryArchiveMedia(ctx context.Context, src videoSource, media internal.FavMediaStruct) error {
+	key := fmt.Sprintf("%s:%d", src.Key(), media.ID)
+	fr, failed := au.store.GetFailure(key)
+	if failed && fr.Count >= maxMediaFailures {
+		log.Debug().Msgf("投稿已连续 %d 次处理失败, 跳过: %s: %s", fr.Count, media.Title, fr.LastError)
+		return nil
+	}
+	err := au.archiveMedia(ctx, src, media)
+	if ctx.Err() != nil {
+		return err
+	}
+	if err == nil {
+		if failed {
+			if err := au.store.DeleteFailure(key); err != nil {
+				log.Error().Err(err).Msgf("删除失败记录失败: %s", media.Title)
+			}
+		}
+		return nil
+	}
+	fr, serr := au.store.AddFailure(key, err)
+	if serr != nil {
+		log.Error().Err(serr).Msgf("记录处理失败的投稿失败: %s", media.Title)
+		return err
+	}
+	if fr.Count >= maxMediaFailures {
+		log.Error().Err(err).Msgf("投稿连续 %d 次处理失败, 不再重试: %s", fr.Count, media.Title)
+		return nil
+	}
+	return err
+}
+
+// 下载投稿元数据和尚未留档的分P, 返回错误时之后的轮次重新处理该投稿
+func (au *ArchiverUser) archiveMedia(ctx context.Context, src videoSource, media internal.FavMediaStruct) error {
+	if au.isPGC(media) {
+		return au.archivePGC(ctx, src, media)
+	}
+	if au.isCourse(media) {
+		return au.archiveCourse(ctx, src, media)
+	}
+	if isAudio(media) {
+		return au.archiveAudio(ctx, src, media)
+	}
+	if au.isArchived(src, media) {
+		log.Debug().Msgf("投稿已留档, 跳过: %s", media.Title)
+		return nil
+	}
+	if au.isSkipped(src, media) {
+		log.Debug().Msgf("投稿已被过滤, 跳过: %s", media.Title)
+		return nil
+	}
+	if reason := src.Settings.Filter.CheckMedia(media); reason != "" {
+		au.skipMedia(src, media, reason)
+		return nil
+	}
+
+	log.Info().Msgf("开始处理投稿: %s", media.Title)
+	vinfo, err := au.bapi.GetView(&internal.ViewReq{
+		Aid: media.ID,
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("获取投稿信息失败: %s", media.Title)
+		return err
+	}
+	// 当稿件失效或信息为空时跳过以避免空指针
+	if vinfo.Arc == nil || vinfo.Ecode != 0 {
+		log.Warn().Msgf("稿件已失效: %s", media.Title)
+		return nil
+	}
+	// 部分来源的列表中没有时长、UP主或发布时间, 用投稿详情补全后再检查
+	if reason := src.Settings.Filter.CheckMedia(viewMedia(media, vinfo)); reason != "" {
+		au.skipMedia(src, media, reason)
+		return nil
+	}
+	if reason := src.Settings.Filter.CheckType(int(vinfo.Arc.TypeId)); reason != "" {
+		au.skipMedia(src, media, reason)
+		return nil
+	}
+
+	// 检查已留档的分P, 全部留档时不再覆盖元数据和封面
+	pages := au.pendingPages(src, vinfo, media.FavTime)
+	if len(pages) == 0 {
+		log.Debug().Msgf("投稿已留档, 跳过: %s", media.Title)
+		return nil
+	}
+
+	au.downloadVideMeta(src, vinfo, media.FavTime)         // 下载投稿元数据
+	return au.downloadVideo(ctx, src, vinfo, media, pages) // 下载投稿
+}
+
+// 用投稿详情补全列表中没有的时长、UP主和发布时间
+func viewMedia(media internal.FavMediaStruct, vinfo *internal.ViewReply) internal.FavMediaStruct {
+	arc := vinfo.Arc
+	if media.Duration == 0 {
+		media.Duration = int(arc.Duration)
+	}
+	if media.Upper.Mid == 0 && arc.Author != nil {
+		media.Upper.Mid = int(arc.Author.Mid)
+		media.Upper.Name = arc.Author.Name
+	}
+	if media.Pubtime == 0 {
+		media.Pubtime = int(arc.Pubdate)
+	}
+	return media
+}
+
+// 投稿是否已被当前的过滤条件跳过
+func (au *ArchiverUser) isSkipped(src videoSource, media internal.FavMediaStruct) bool {
+	rec, ok := au.store.GetVideo(media.ID)
+	if !ok {
+		return false
+	}
+	skip, ok := rec.Skipped[src.Key()]
+	if !ok && au.primary {
+		skip, ok = rec.Skipped[src.legacyKey()]
+	}
+	return ok && skip.Filter == src.Settings.Filter.Key()
+}
+
+// 跳过不满足过滤条件的投稿并记录, 之后的轮次不再检查
+func (au *ArchiverUser) skipMedia(src videoSource, media internal.FavMediaStruct, reason string) {
+	log.Info().Msgf("投稿不满足过滤条件, 跳过: %s: %s", media.Title, reason)
+	err := au.store.UpdateVideo(media.ID, func(rec *internal.VideoRecord) {
+		if rec.Bvid == "" {
+			rec.Mid = au.mid() // 已由其他账号留档的投稿保留原账号
+			rec.Bvid = media.Bvid
+			rec.Title = media.Title
+		}
+		if rec.Skipped == nil {
+			rec.Skipped = make(map[string]internal.SkipRecord)
+		}
+		rec.Skipped[src.Key()] = internal.SkipRecord{
+			Reason:    reason,
+			Filter:    src.Settings.Filter.Key(),
+			SkippedAt: int(time.Now().Unix()),
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("记录跳过的投稿失败: %s", media.Title)
+	}
+}
@@ -0,0 +1,348 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	archiveapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/app/archive/v1"
+	viewapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/app/view/v1"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+// 模拟接口, 只实现处理收藏夹用到的方法, 其他方法调用时 panic
+type fakeAPI struct {
+	internal.BiliAPI
+	medias   []internal.FavMediaStruct
+	viewErrs map[int64]error // 获取投稿信息时返回错误的投稿
+}
+
+func (f *fakeAPI) GetFavMediaList(fid, pn int) (internal.FavMediaListStruct, error) {
+	var list internal.FavMediaListStruct
+	if pn == 1 {
+		list.Medias = f.medias
+	}
+	return list, nil
+}
+
+func (f *fakeAPI) GetView(req *internal.ViewReq) (*internal.ViewReply, error) {
+	if err := f.viewErrs[req.Aid]; err != nil {
+		return nil, err
+	}
+	return &internal.ViewReply{
+		Bvid: fmt.Sprintf("BV%d", req.Aid),
+		Arc: &archiveapi.Arc{
+			Aid:      req.Aid,
+			Title:    fmt.Sprintf("投稿%d", req.Aid),
+			Duration: 60,
+			Author:   &archiveapi.Author{Mid: 1, Name: "UP"},
+		},
+		Pages: []*viewapi.ViewPage{{Page: &archiveapi.Page{Cid: req.Aid * 10, Page: 1}}},
+	}, nil
+}
+
+func (f *fakeAPI) GetPlayURL(aid, cid int64, fnval int) (internal.PlayInfoStruct, error) {
+	var pi internal.PlayInfoStruct
+	pi.Dash.Video = []internal.DashStream{{ID: 80, BaseURL: fmt.Sprintf("http://cdn/%d.m4s", cid), Codecs: "avc1"}}
+	pi.Dash.Audio = []internal.DashStream{{ID: 30280, BaseURL: fmt.Sprintf("http://cdn/%d-audio.m4s", cid), Codecs: "mp4a"}}
+	return pi, nil
+}
+
+// 模拟下载管理器, 只记录添加的任务
+type fakeDownloader struct {
+	mu    sync.Mutex
+	tasks []*internal.DownloadTask
+}
+
+func (f *fakeDownloader) RegisterClient(mid int64, client internal.BiliAPI) {}
+func (f *fakeDownloader) RegisterTaskGroup(info internal.TaskGroupInfo, callbacks internal.TaskGroupCallbacks) {
+}
+func (f *fakeDownloader) RegisterGroupRestorer(mid int64, restore internal.TaskGroupRestorer) {}
+func (f *fakeDownloader) ReportTaskResult(groupID string, cid int64, pdir string, err error)  {}
+func (f *fakeDownloader) Fetch(url string) ([]byte, error)                                    { return nil, errors.New("offline") }
+func (f *fakeDownloader) FetchFile(url, filePath string) error                                { return errors.New("offline") }
+
+func (f *fakeDownloader) AddTask(task *internal.DownloadTask) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tasks = append(f.tasks, task)
+}
+
+func (f *fakeDownloader) aids() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var aids []int64
+	for _, t := range f.tasks {
+		aids = append(aids, t.Aid)
+	}
+	return aids
+}
+
+func newTestUser(t *testing.T, api internal.BiliAPI, dm internal.Downloader) (*ArchiverUser, *internal.ArchiveStore) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	yaml := fmt.Sprintf("save_path: %s\ndb_path: %s\ndanmaku: false\n", filepath.Join(dir, "videos"), filepath.Join(dir, "archiver.db"))
+	if err := os.WriteFile(configPath, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := internal.LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := internal.OpenArchiveStore(config.DBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	au := NewArchiverUser(*config, api, dm, store, true)
+	au.buser.Mid = 42
+	au.buser.Uname = "测试用户"
+	return au, store
+}
+
+func testMedia(aid int64, favtime int) internal.FavMediaStruct {
+	m := internal.FavMediaStruct{ID: aid, Type: 2, Title: fmt.Sprintf("投稿%d", aid), FavTime: favtime}
+	m.Ugc.FirstCid = aid * 10
+	return m
+}
+
+func TestArchiveSourceAddsTasksAndSavesCheckpoint(t *testing.T) {
+	api := &fakeAPI{medias: []internal.FavMediaStruct{testMedia(3, 300), testMedia(2, 200), testMedia(1, 100)}}
+	dm := &fakeDownloader{}
+	au, store := newTestUser(t, api, dm)
+
+	src := videoSource{Kind: sourceFav, ID: 7, Name: "收藏夹"}
+	au.archiveSource(context.Background(), src, au.favPager(7), false, 0)
+
+	if got := dm.aids(); len(got) != 3 || got[0] != 3 || got[2] != 1 {
+		t.Fatalf("添加的任务 = %v, 预期 [3 2 1]", got)
+	}
+	src.Mid = au.mid()
+	cp, ok := store.GetCheckpoint(src.Key())
+	if !ok || cp.MediaID != 3 || cp.FavTime != 300 {
+		t.Fatalf("断点 = %+v (%v), 预期最新投稿 3", cp, ok)
+	}
+	rec, ok := store.GetVideo(2)
+	if !ok || rec.Source != src.Key() || rec.GetPage(20) == nil {
+		t.Fatalf("留档记录 = %+v (%v)", rec, ok)
+	}
+	// 元数据中记录实际下载的音视频流
+	if st := readStreams(rec.MetaPath)["P1"]; st.Quality != 80 || st.VideoCodecs != "avc1" || st.Container != "mp4" {
+		t.Fatalf("元数据中的音视频流 = %+v", st)
+	}
+
+	// 再次处理时断点之前的投稿不再添加任务
+	au.archiveSource(context.Background(), videoSource{Kind: sourceFav, ID: 7, Name: "收藏夹"}, au.favPager(7), false, 0)
+	if got := dm.aids(); len(got) != 3 {
+		t.Fatalf("重复添加任务: %v", got)
+	}
+}
+
+func TestArchiveSourceKeepsCheckpointBeforeFailure(t *testing.T) {
+	api := &fakeAPI{
+		medias:   []internal.FavMediaStruct{testMedia(3, 300), testMedia(2, 200), testMedia(1, 100)},
+		viewErrs: map[int64]error{2: errors.New("view failed")},
+	}
+	dm := &fakeDownloader{}
+	au, store := newTestUser(t, api, dm)
+
+	src := videoSource{Kind: sourceFav, ID: 7, Name: "收藏夹"}
+	au.archiveSource(context.Background(), src, au.favPager(7), false, 0)
+
+	if got := dm.aids(); len(got) != 2 || got[0] != 3 || got[1] != 1 {
+		t.Fatalf("添加的任务 = %v, 预期 [3 1]", got)
+	}
+	src.Mid = au.mid()
+	cp, ok := store.GetCheckpoint(src.Key())
+	if !ok || cp.MediaID != 1 || cp.FavTime != 100 {
+		t.Fatalf("断点 = %+v (%v), 预期停留在失败投稿之前的 1", cp, ok)
+	}
+
+	// 接口恢复后从断点重新处理, 尚未下载完成的 3 再次添加, 由下载队列去重
+	api.viewErrs = nil
+	au.archiveSource(context.Background(), videoSource{Kind: sourceFav, ID: 7, Name: "收藏夹"}, au.favPager(7), false, 0)
+	if got := dm.aids(); len(got) != 4 || got[3] != 2 {
+		t.Fatalf("添加的任务 = %v, 预期重新处理 2", got)
+	}
+	if cp, _ := store.GetCheckpoint(src.Key()); cp.MediaID != 3 {
+		t.Fatalf("断点 = %+v, 预期推进到 3", cp)
+	}
+}
+
+func TestArchiveSourceGivesUpAfterRepeatedFailures(t *testing.T) {
+	api := &fakeAPI{
+		medias:   []internal.FavMediaStruct{testMedia(2, 200), testMedia(1, 100)},
+		viewErrs: map[int64]error{2: errors.New("view failed")},
+	}
+	dm := &fakeDownloader{}
+	au, store := newTestUser(t, api, dm)
+
+	src := videoSource{Kind: sourceFav, ID: 7, Name: "收藏夹", Mid: au.mid()}
+	for round := 1; round <= maxMediaFailures; round++ {
+		au.archiveSource(context.Background(), src, au.favPager(7), true, 0)
+		cp, _ := store.GetCheckpoint(src.Key())
+		if round < maxMediaFailures && cp.MediaID != 1 {
+			t.Fatalf("第 %d 轮断点 = %+v, 预期停留在 1", round, cp)
+		}
+		if round == maxMediaFailures && cp.MediaID != 2 {
+			t.Fatalf("第 %d 轮断点 = %+v, 预期越过失败的投稿 2", round, cp)
+		}
+	}
+	fr, ok := store.GetFailure(fmt.Sprintf("%s:%d", src.Key(), 2))
+	if !ok || fr.Count != maxMediaFailures {
+		t.Fatalf("失败记录 = %+v (%v)", fr, ok)
+	}
+}
+
+func TestUntimedSourceFallsBackToAnchors(t *testing.T) {
+	api := &fakeAPI{}
+	dm := &fakeDownloader{}
+	au, store := newTestUser(t, api, dm)
+
+	var list []internal.FavMediaStruct
+	pager := func(pn int) ([]internal.FavMediaStruct, bool, error) {
+		if pn > 1 {
+			return nil, false, nil
+		}
+		return list, false, nil
+	}
+	src := videoSource{Kind: sourceLiked, ID: 42, Name: "点赞的视频", Untimed: true}
+
+	// 首次处理只记录位置
+	list = []internal.FavMediaStruct{testMedia(5, 500), testMedia(4, 400), testMedia(3, 300)}
+	au.archiveSource(context.Background(), src, pager, false, 0)
+	if got := dm.aids(); len(got) != 0 {
+		t.Fatalf("首次处理添加了任务: %v", got)
+	}
+	src.Mid = au.mid()
+	if cp, _ := store.GetCheckpoint(src.Key()); cp.MediaID != 5 || len(cp.Anchors) != 3 {
+		t.Fatalf("断点 = %+v", cp)
+	}
+
+	// 断点投稿被取消点赞后, 只处理新点赞的投稿
+	list = []internal.FavMediaStruct{testMedia(6, 600), testMedia(4, 400), testMedia(3, 300)}
+	au.archiveSource(context.Background(), src, pager, false, 0)
+	if got := dm.aids(); len(got) != 1 || got[0] != 6 {
+		t.Fatalf("添加的任务 = %v, 预期 [6]", got)
+	}
+	if cp, _ := store.GetCheckpoint(src.Key()); cp.MediaID != 6 || !slices.Contains(cp.Anchors, 4) {
+		t.Fatalf("断点 = %+v", cp)
+	}
+}
+
+func TestVirtualFoldersIgnoreKeywords(t *testing.T) {
+	au, _ := newTestUser(t, &fakeAPI{}, &fakeDownloader{})
+	au.config.Keywords = []string{"留档"}
+	if !au.config.FolderEnabled(sourceLiked, 42, "点赞的视频") {
+		t.Fatal("点赞的视频不应按关键词过滤")
+	}
+	if au.config.FolderEnabled(sourceFav, 1, "默认收藏夹") {
+		t.Fatal("收藏夹应按关键词过滤")
+	}
+}
+
+// 课程接口, 第二集模拟未购买
+type courseAPI struct {
+	fakeAPI
+}
+
+func (f *courseAPI) GetCourseSeason(seasonID, epID int64) (internal.PGCSeasonStruct, error) {
+	var c internal.CourseSeasonStruct
+	c.SeasonID = 9
+	c.Title = "课程"
+	for i := int64(1); i <= 2; i++ {
+		c.Episodes = append(c.Episodes, internal.CourseEpisodeStruct{ID: 900 + i, Aid: 90 + i, Cid: 9000 + i, Title: fmt.Sprintf("第%d课", i), Index: int(i), Duration: 60})
+	}
+	return c.PGCSeason(), nil
+}
+
+func (f *courseAPI) GetCoursePlayURL(aid, cid, epid int64, fnval int) (internal.PlayInfoStruct, error) {
+	if epid == 902 {
+		return internal.PlayInfoStruct{}, errors.New("未购买")
+	}
+	return f.GetPlayURL(aid, cid, fnval)
+}
+
+func TestArchiveCourse(t *testing.T) {
+	course := internal.FavMediaStruct{ID: 9, Type: 24, Title: "课程", FavTime: 100, Link: "https://www.bilibili.com/cheese/play/ss9"}
+	api := &courseAPI{fakeAPI{medias: []internal.FavMediaStruct{course}}}
+	dm := &fakeDownloader{}
+	au, store := newTestUser(t, api, dm)
+
+	src := videoSource{Kind: sourceFav, ID: 7, Name: "收藏夹"}
+	au.archiveSource(context.Background(), src, au.favPager(7), true, 0)
+
+	if len(dm.tasks) != 1 || dm.tasks[0].Aid != 91 || !dm.tasks[0].Course || dm.tasks[0].EpID != 901 {
+		t.Fatalf("添加的任务 = %v, 预期课程第一集", dm.aids())
+	}
+	// 未购买的单集获取播放地址失败, 断点不越过该课程
+	src.Mid = au.mid()
+	if cp, ok := store.GetCheckpoint(src.Key()); ok && cp.MediaID == course.ID {
+		t.Fatalf("断点 = %+v, 预期停留在课程之前", cp)
+	}
+}
+
+// 被规则排除的UP主和稍后再看不请求投稿列表, 调用未实现的接口会 panic
+type cardAPI struct {
+	fakeAPI
+}
+
+func (f *cardAPI) GetUserCard(mid int) (internal.UserCardStruct, error) {
+	var card internal.UserCardStruct
+	card.Card.Name = fmt.Sprintf("UP%d", mid)
+	return card, nil
+}
+
+func TestExcludedUppersAndToViewSkipped(t *testing.T) {
+	au, _ := newTestUser(t, &cardAPI{}, &fakeDownloader{})
+	au.config.Uppers = []int{5}
+	au.config.Rules = []internal.FolderRule{
+		{Kind: []string{sourceUpper}, ID: []int64{5}, Exclude: true},
+		{Kind: []string{sourceToView}, Exclude: true},
+	}
+	au.archiveUppers(context.Background(), true, 0)
+	au.archiveToView(context.Background(), true, 0)
+}
+
+func TestImportMetaFilesPerAccount(t *testing.T) {
+	primary, store := newTestUser(t, &fakeAPI{}, &fakeDownloader{})
+	secondConfig := primary.config
+	secondConfig.SavePath = filepath.Join(t.TempDir(), "videos")
+	second := NewArchiverUser(secondConfig, &fakeAPI{}, &fakeDownloader{}, store, false)
+	second.buser.Mid = 43
+
+	// 两个账号的存储目录中有同一投稿的元数据
+	var paths []string
+	for _, au := range []*ArchiverUser{primary, second} {
+		path := filepath.Join(au.config.SavePath, "收藏夹", "BV1_meta.json")
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(`{"aid": 1, "title": "投稿1"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	primary.importMetaFiles()
+	second.importMetaFiles()
+
+	rec, ok := store.GetVideo(1)
+	if !ok || rec.MetaPath != paths[0] || len(rec.AllMetaPaths()) != 2 {
+		t.Fatalf("留档记录 = %+v (%v)", rec, ok)
+	}
+	// 每个账号只更新自己存储目录中的元数据
+	for i, au := range []*ArchiverUser{primary, second} {
+		owned := slices.Collect(maps.Values(au.ownMetaPaths(rec)))
+		if len(owned) != 1 || owned[0] != paths[i] {
+			t.Fatalf("账号 %d 更新的元数据 = %v, 预期 %s", au.mid(), owned, paths[i])
+		}
+	}
+}
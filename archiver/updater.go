@@ -1,11 +1,14 @@
 package archiver
 
 import (
+	"context"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,13 +18,8 @@ import (
 
 // 用于更新已下载投稿的元数据和弹幕
 
-type VideoMetaPath struct {
-	Path string
-	Meta internal.VideoMetaStruct
-}
-
 func (au *ArchiverUser) getAllMetaFiles() []string {
-	// 获取所有下载的投稿的元数据路径 _meta.json
+	// 获取账号存储目录下所有投稿的元数据路径 _meta.json
 	var metaPaths []string
 	filepath.Walk(au.config.SavePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -39,55 +37,129 @@ func (au *ArchiverUser) getAllMetaFiles() []string {
 	return metaPaths
 }
 
-func (au *ArchiverUser) UpdateVideoMeta() {
+// 账号还没有留档记录时, 从账号的存储目录导入已有的元数据文件 (仅需执行一次)
+// 多个账号共用存储目录时, 已被之前的账号导入的文件不再重复导入
+func (au *ArchiverUser) importMetaFiles() {
+	recs, err := au.store.ListVideos()
+	if err != nil {
+		log.Error().Err(err).Msg("读取留档状态数据库失败")
+		return
+	}
+	imported := make(map[string]bool)
+	for _, rec := range recs {
+		if len(au.ownMetaPaths(rec)) > 0 {
+			return
+		}
+		for _, path := range rec.AllMetaPaths() {
+			imported[path] = true
+		}
+	}
+	var metaPaths []string
+	for _, path := range au.getAllMetaFiles() {
+		if !imported[path] {
+			metaPaths = append(metaPaths, path)
+		}
+	}
+	if len(metaPaths) == 0 {
+		return
+	}
+	log.Info().Msgf("导入已有元数据文件, 共 %d 个: %s", len(metaPaths), au.config.SavePath)
+	for _, metaPath := range metaPaths {
+		var meta internal.VideoMetaStruct
+		data, err := os.ReadFile(metaPath)
+		if err != nil {
+			log.Error().Err(err).Msgf("打开元数据文件失败: %s", metaPath)
+			continue
+		}
+		if err = json.Unmarshal(data, &meta); err != nil || meta.Aid == 0 {
+			log.Error().Err(err).Msgf("解析元数据文件失败: %s", metaPath)
+			continue
+		}
+		var mtime int
+		if fi, err := os.Stat(metaPath); err == nil {
+			mtime = int(fi.ModTime().Unix())
+		}
+		err = au.store.UpdateVideo(meta.Aid, func(rec *internal.VideoRecord) {
+			// 导入的文件没有来源信息, 以 <mid>:import:<路径> 记录
+			rec.SetMetaPath(fmt.Sprintf("%d:import:%s", au.mid(), metaPath), metaPath)
+			if rec.MetaPath != "" {
+				return
+			}
+			rec.Title = meta.Title
+			rec.Ctime = meta.Ctime
+			rec.Mid = au.mid()
+			rec.MetaPath = metaPath
+			rec.MetaUpdatedAt = mtime
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("导入元数据失败: %s", metaPath)
+		}
+	}
+}
+
+// 需要更新的元数据文件和所属来源生效的设置
+type metaFile struct {
+	path     string
+	settings internal.FolderSettings
+}
+
+// 定期更新元数据和弹幕, ctx 取消时返回
+func (au *ArchiverUser) UpdateVideoMeta(ctx context.Context) {
 	for {
-		time.Sleep(time.Duration(au.config.UpdateInterval) * time.Minute)
+		if internal.SleepContext(ctx, time.Duration(au.config.UpdateInterval)*time.Minute) != nil {
+			return
+		}
 		au.bapi.InitGRPC()
-		metaPaths := au.getAllMetaFiles()
-		var vmetas []VideoMetaPath
-		for _, metaPath := range metaPaths {
-			var meta internal.VideoMetaStruct
-			metaFile, err := os.Open(metaPath)
-			if err != nil {
-				log.Error().Err(err).Msgf("打开元数据文件失败: %s", metaPath)
+		recs, err := au.store.ListVideos()
+		if err != nil {
+			log.Error().Err(err).Msg("读取留档状态数据库失败")
+			continue
+		}
+		var vmetas []internal.VideoRecord
+		files := make(map[int64][]metaFile)
+		for _, rec := range recs {
+			if rec.Deleted {
 				continue
 			}
-			err = json.NewDecoder(metaFile).Decode(&meta)
-			if err != nil {
-				log.Error().Err(err).Msgf("解析元数据文件失败: %s", metaPath)
-				continue
+			owned := au.ownMetaPaths(rec)
+			for _, key := range slices.Sorted(maps.Keys(owned)) {
+				// 当前时间前 n 天 用于判断是否需要更新投稿信息, n 可按收藏夹规则设置
+				fs := au.recordSettings(rec)
+				if key != rec.Source {
+					fs = au.sourceSettings(key, "", 0)
+				}
+				t := int(time.Now().AddDate(0, 0, -fs.UpdateDL).Unix())
+				if rec.Ctime > t {
+					files[rec.Aid] = append(files[rec.Aid], metaFile{path: owned[key], settings: fs})
+				}
 			}
-			// 当前时间前 n 天 用于判断是否需要更新投稿信息
-			t := int(time.Now().AddDate(0, 0, -au.config.UpdateDL).Unix())
-			if meta.Ctime > t {
-				vmetas = append(vmetas, VideoMetaPath{
-					Path: metaPath,
-					Meta: meta,
-				})
-
+			if len(files[rec.Aid]) > 0 {
+				vmetas = append(vmetas, rec)
 			}
 		}
 
 		// time.Sleep(time.Duration(au.config.UpdateInterval) * time.Minute)
 		log.Info().Msgf("开始更新元数据, 共 %d 个投稿在更新范围", len(vmetas))
 		for _, vmeta := range vmetas {
-			// log.Debug().Msgf("更新元数据: %s", vmeta.Path)
+			if ctx.Err() != nil {
+				log.Info().Msg("元数据更新已中断")
+				return
+			}
+			// log.Debug().Msgf("更新元数据: %s", vmeta.MetaPath)
 			vinfo, err := au.bapi.GetView(&internal.ViewReq{
-				Aid: vmeta.Meta.Aid,
+				Aid: vmeta.Aid,
 			})
 			if err != nil {
-				log.Error().Err(err).Msgf("获取投稿信息失败: %s", vmeta.Path)
+				log.Error().Err(err).Msgf("获取投稿信息失败: %s", vmeta.Title)
 				continue
 			}
 			if vinfo.Arc == nil || vinfo.Ecode != 0 {
-				log.Warn().Msgf("稿件已失效: %s", vmeta.Meta.Title)
+				log.Warn().Msgf("稿件已失效: %s", vmeta.Title)
 				// 发送通知
 				if au.config.Notification != "" {
-					msg := fmt.Sprintf("稿件已失效: %s\n", vmeta.Meta.Title)
+					msg := fmt.Sprintf("稿件已失效: %s\n", vmeta.Title)
 					// 获取最后记录时间
-					tmp, _ := os.Stat(vmeta.Path)
-					last := tmp.ModTime()
-					msg += fmt.Sprintf("最后记录时间: %s", last.Format("2006-01-02 15:04:05"))
+					msg += fmt.Sprintf("最后记录时间: %s", internal.FormatTime(vmeta.MetaUpdatedAt))
 					err = internal.SendNotification(au.config.Notification, msg, au.config.NotificationProxy)
 					if err != nil {
 						log.Error().Err(err).Msg("发送通知失败")
@@ -95,35 +167,86 @@ func (au *ArchiverUser) UpdateVideoMeta() {
 						log.Info().Msg("发送通知成功")
 					}
 				}
-				// 重命名元文件到  _deleted.json
-				newPath := strings.Replace(vmeta.Path, "_meta.json", "_meta_deleted.json", 1)
-				os.Rename(vmeta.Path, newPath)
+				// 重命名所有账号的元文件到  _deleted.json
+				err = au.store.UpdateVideo(vmeta.Aid, func(rec *internal.VideoRecord) {
+					rec.Deleted = true
+					for _, path := range rec.AllMetaPaths() {
+						os.Rename(path, deletedMetaPath(path))
+					}
+					rec.MetaPath = deletedMetaPath(rec.MetaPath)
+					for key, path := range rec.MetaPaths {
+						rec.MetaPaths[key] = deletedMetaPath(path)
+					}
+				})
+				if err != nil {
+					log.Error().Err(err).Msgf("记录稿件失效失败: %s", vmeta.Title)
+				}
 				continue
 			}
 			// 更新元数据
-			jsonData, _ := json.MarshalIndent(vinfo.Arc, "", "  ")
-			f, err := os.Create(vmeta.Path)
-			if err != nil {
-				log.Error().Err(err).Msgf("创建文件失败: %s", vmeta.Path)
-				return
+			updated := false
+			for _, file := range files[vmeta.Aid] {
+				// 保留留档时记录的音视频流
+				err = writeMeta(file.path, videoMeta{Arc: vinfo.Arc, Streams: readStreams(file.path)})
+				if err != nil {
+					log.Error().Err(err).Msgf("写入元数据文件失败: %s", file.path)
+					continue
+				}
+				updated = true
+				// 更新弹幕
+				if file.settings.Danmaku {
+					au.updateDanmaku(file.path)
+				}
+				if file.settings.RunAfterUpdate != "" {
+					pdir := filepath.Dir(file.path)
+					internal.ExecCommand(ctx, file.settings.RunAfterUpdate, pdir)
+				}
 			}
-			defer f.Close()
-			f.WriteString(string(jsonData))
-			log.Debug().Msgf("更新投稿元数据完成: %s", vinfo.Arc.Title)
-			// 更新弹幕
-			if au.config.Danmaku {
-				au.updateDanmaku(vmeta.Path)
+			if !updated {
+				continue
 			}
-
-			if au.config.RunAfterUpdate != "" {
-				pdir := filepath.Dir(vmeta.Path)
-				internal.ExecCommand(au.config.RunAfterUpdate, pdir)
+			err = au.store.UpdateVideo(vmeta.Aid, func(rec *internal.VideoRecord) {
+				rec.Title = vinfo.Arc.Title
+				rec.MetaUpdatedAt = int(time.Now().Unix())
+			})
+			if err != nil {
+				log.Error().Err(err).Msgf("记录元数据更新时间失败: %s", vinfo.Arc.Title)
 			}
+			log.Debug().Msgf("更新投稿元数据完成: %s", vinfo.Arc.Title)
 		}
 		log.Info().Msg("元数据更新完成")
 	}
 }
 
+// 失效稿件的元数据文件路径
+func deletedMetaPath(path string) string {
+	return strings.Replace(path, "_meta.json", "_meta_deleted.json", 1)
+}
+
+// 记录是否由当前账号更新, 没有账号信息的旧记录由第一个账号更新
+func (au *ArchiverUser) ownsRecord(rec internal.VideoRecord) bool {
+	if rec.Mid == 0 {
+		return au.primary
+	}
+	return rec.Mid == au.mid()
+}
+
+// 由当前账号更新的元数据文件, 按来源 key 返回
+// 只记录在 MetaPath 中的旧路径以 rec.Source 为 key, 由记录所属的账号更新
+func (au *ArchiverUser) ownMetaPaths(rec internal.VideoRecord) map[string]string {
+	owned := make(map[string]string)
+	prefix := fmt.Sprintf("%d:", au.mid())
+	for key, path := range rec.MetaPaths {
+		if strings.HasPrefix(key, prefix) {
+			owned[key] = path
+		}
+	}
+	if rec.MetaPath != "" && au.ownsRecord(rec) && !slices.Contains(slices.Collect(maps.Values(rec.MetaPaths)), rec.MetaPath) {
+		owned[rec.Source] = rec.MetaPath
+	}
+	return owned
+}
+
 func (au *ArchiverUser) updateDanmaku(vpath string) {
 	// 更新弹幕
 	pdir := filepath.Dir(vpath)
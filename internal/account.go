@@ -0,0 +1,56 @@
+package internal
+
+import "fmt"
+
+// 账号配置, 未设置的项使用全局配置
+type AccountConfig struct {
+	User         string       `yaml:"user"`          // cookie文件路径
+	SavePath     string       `yaml:"save_path"`     // 投稿存储目录
+	PathTemplate string       `yaml:"path_template"` // 存储路径模板
+	Keywords     []string     `yaml:"keywords"`      // 收藏夹关键词过滤
+	Uppers       []int        `yaml:"uppers"`        // 监控的UP主 mid
+	Rules        []FolderRule `yaml:"rules"`         // 收藏夹规则, 设置后替换全局规则
+}
+
+// 检查账号配置并预先解析规则
+func (a *AccountConfig) compile() error {
+	if a.User == "" {
+		return fmt.Errorf("账号未设置 cookie 文件")
+	}
+	for i := range a.Rules {
+		if err := a.Rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取每个账号生效的配置, 没有配置 accounts 时只有 user 一个账号
+func (c *Config) AccountConfigs() []Config {
+	if len(c.Accounts) == 0 {
+		return []Config{*c}
+	}
+	configs := make([]Config, 0, len(c.Accounts))
+	for _, a := range c.Accounts {
+		ac := *c
+		ac.User = a.User
+		if a.SavePath != "" {
+			ac.SavePath = a.SavePath
+		}
+		if a.PathTemplate != "" {
+			ac.PathTemplate = a.PathTemplate
+		}
+		if a.Keywords != nil {
+			ac.Keywords = a.Keywords
+		}
+		if a.Uppers != nil {
+			ac.Uppers = a.Uppers
+		}
+		if a.Rules != nil {
+			ac.Rules = a.Rules
+		}
+		ac.Accounts = nil
+		configs = append(configs, ac)
+	}
+	return configs
+}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"crypto/md5"
 	"path"
 	"strings"
@@ -12,6 +13,7 @@ import (
 	"net/url"
 	"os"
 	"sort"
+	"sync"
 	"time"
 
 	"github.com/imroc/req/v3"
@@ -81,6 +83,9 @@ func (ck *CookieInfoStruct) SaveToFile(cfName string) error {
 }
 
 type BApiClient struct {
+	ctx        context.Context // 程序退出时取消所有请求
+	config     *Config         // 接口地址、代理和通知设置
+	limiter    *RateLimiter    // 所有账号共用的限速器
 	client     *req.Client
 	cookieFile string
 	wbi        *WBI
@@ -90,12 +95,27 @@ type BApiClient struct {
 	dmClient      dmapi.DMClient
 	playurlClient playapi.PlayURLClient
 	viewClient    viewapi.ViewClient
+
+	refreshMutex sync.Mutex // 避免同时刷新 cookie
 }
 
-// NewBApiClient 创建并初始化 BApiClient
-func NewBApiClient() *BApiClient {
+// NewBApiClient 创建并初始化 BApiClient, ctx 取消后所有请求立即返回
+// 所有账号的客户端应共用同一个 limiter; config 为 nil 时使用默认接口地址, limiter 为 nil 时不限速
+func NewBApiClient(ctx context.Context, config *Config, limiter *RateLimiter) *BApiClient {
+	if config == nil {
+		config = &Config{}
+	}
+	if limiter == nil {
+		limiter = NewRateLimiter(RateLimitConfig{})
+	}
+	ba := &BApiClient{
+		ctx:     ctx,
+		config:  config,
+		limiter: limiter,
+		wbi:     NewDefaultWbi(),
+	}
 	// 初始化req.Client
-	c := req.C().
+	ba.client = req.C().
 		SetUserAgent("Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/134.0.0.0 Safari/537.36 Edg/134.0.0.0").
 		SetTimeout(5*time.Second).
 		SetCommonErrorResult(&BiliErr{}).
@@ -103,6 +123,10 @@ func NewBApiClient() *BApiClient {
 		SetCommonRetryCount(3).
 		SetCommonRetryBackoffInterval(1*time.Second, 2*time.Second).
 		SetCommonRetryCondition(func(resp *req.Response, err error) bool {
+			// 程序退出时不再重试
+			if ba.ctx.Err() != nil {
+				return false
+			}
 			// 如果有网络错误或其他HTTP错误，进行重试
 			if err != nil {
 				// 如果是B站API错误也进行重试
@@ -110,10 +134,12 @@ func NewBApiClient() *BApiClient {
 					// -101 可能是未登录或需要重新登录
 					if err.Code == -101 {
 						log.Warn().Msg("cookie 失效，刷新 cookie")
-						RefreshToken(GlobalConfig.User)
+						ba.AutoRefreshCookie()
 						return false
 					} else if err.Code == 86039 {
 						return false // 忽略 扫码登录影响
+					} else if IsRiskCode(err.Code) {
+						return false // 触发风控时等待冷却, 不重试
 					}
 				}
 				log.Warn().Stack().Err(err).Msg("请求失败, 进行重试")
@@ -122,7 +148,17 @@ func NewBApiClient() *BApiClient {
 
 			return false
 		}).
+		// 每次请求(包括重试)前等待风控冷却和限速
+		OnBeforeRequest(func(client *req.Client, r *req.Request) error {
+			return ba.limiter.Wait(ba.ctx, r.RawURL)
+		}).
 		OnAfterResponse(func(client *req.Client, resp *req.Response) error {
+			// HTTP 412 为风控拦截, 响应不是 JSON
+			if resp.StatusCode == http.StatusPreconditionFailed {
+				err := &BiliErr{Code: -412, Message: "请求被拦截"}
+				ba.limiter.TriggerRisk(resp.Request.RawURL, err)
+				return err
+			}
 			// 解析响应
 			var biliResp BiliResp
 			if err := resp.UnmarshalJson(&biliResp); err != nil {
@@ -130,15 +166,23 @@ func NewBApiClient() *BApiClient {
 			}
 			// 检查响应码
 			if biliResp.Code != 0 {
-				return &BiliErr{
+				err := &BiliErr{
 					Code:    biliResp.Code,
 					Message: biliResp.Message,
 				}
+				if IsRiskCode(err.Code) {
+					ba.limiter.TriggerRisk(resp.Request.RawURL, err)
+				}
+				return err
 			}
 			// 如果响应码为0，使用Data字段重写结果
 			if result := resp.SuccessResult(); result != nil {
+				data := biliResp.Data
+				if data == nil {
+					data = biliResp.Result
+				}
 				// 将 Data 重新序列化为 JSON
-				dataBytes, err := json.Marshal(biliResp.Data)
+				dataBytes, err := json.Marshal(data)
 				if err != nil {
 					return fmt.Errorf("序列化数据失败: %w", err)
 				}
@@ -150,10 +194,13 @@ func NewBApiClient() *BApiClient {
 			return nil
 		})
 
-	return &BApiClient{
-		client: c,
-		wbi:    NewDefaultWbi(),
+	if config.Proxy.API != "" {
+		ba.client.SetProxyURL(config.Proxy.API)
 	}
+	if reqDevLogger != nil {
+		ba.SetDev(reqDevLogger)
+	}
+	return ba
 }
 
 func (ba *BApiClient) SetDev(log req.Logger) {
@@ -170,7 +217,7 @@ func (ba *BApiClient) GET(api string, bf *BiliFrom, resuult any, wbi ...any) err
 	} else {
 		bf = NewBiliFrom(map[string]any{})
 	}
-	_, err := ba.client.R().SetQueryParamsAnyType(bf.Get()).SetSuccessResult(resuult).Get(api)
+	_, err := ba.client.R().SetContext(ba.ctx).SetQueryParamsAnyType(bf.Get()).SetSuccessResult(resuult).Get(ba.config.Endpoints.rewrite(api))
 	if err != nil {
 		return err
 	}
@@ -183,7 +230,7 @@ func (ba *BApiClient) POST(api string, bf *BiliFrom, resuult any) error {
 	} else {
 		bf = NewBiliFrom(map[string]any{})
 	}
-	_, err := ba.client.R().SetFormDataAnyType(bf.Get()).SetSuccessResult(resuult).Post(api)
+	_, err := ba.client.R().SetContext(ba.ctx).SetFormDataAnyType(bf.Get()).SetSuccessResult(resuult).Post(ba.config.Endpoints.rewrite(api))
 	if err != nil {
 		return err
 	}
@@ -262,6 +309,41 @@ func (ba *BApiClient) RefreshToken() (CookieInfoStruct, error) {
 	return result, nil
 }
 
+// 刷新 cookie 文件中的登录凭证, 保存后重新加载
+func (ba *BApiClient) RefreshCookie() error {
+	cookieInfo, err := ba.RefreshToken()
+	if err != nil {
+		return err
+	}
+	if err := cookieInfo.SaveToFile(ba.cookieFile); err != nil {
+		return err
+	}
+	if err := ba.SetCookieFile(ba.cookieFile); err != nil {
+		return err
+	}
+	uf, err := ba.GetUserInfo()
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("%s UID: %v Cookie 刷新成功", uf.Uname, uf.Mid)
+	return nil
+}
+
+// 运行中自动刷新 cookie, 失败时发送通知, 已有刷新在进行时直接返回
+func (ba *BApiClient) AutoRefreshCookie() {
+	if !ba.refreshMutex.TryLock() {
+		return
+	}
+	defer ba.refreshMutex.Unlock()
+	if err := ba.RefreshCookie(); err != nil {
+		log.Error().Err(err).Msgf("刷新 cookie 失败: %s", ba.cookieFile)
+		if ba.config.Notification != "" {
+			msg := fmt.Sprintf("自动刷新 cookie 失败: %s: %v", ba.cookieFile, err)
+			SendNotification(ba.config.Notification, msg, ba.config.NotificationProxy)
+		}
+	}
+}
+
 func (ba *BApiClient) CheckToken() (TokenInfoStruct, error) {
 	api := "https://passport.bilibili.com/x/passport-login/oauth2/info"
 	bf := NewBiliFrom(map[string]any{
@@ -288,13 +370,13 @@ func (ba *BApiClient) GetUserInfo() (UserInfoStruct, error) {
 	return result, nil
 }
 
-func (ba *BApiClient) GetPlayURL(aid, cid int64) (PlayInfoStruct, error) {
+func (ba *BApiClient) GetPlayURL(aid, cid int64, fnval int) (PlayInfoStruct, error) {
 	// ba.GetUserInfo() // 更新 wbi
 	api := "https://api.bilibili.com/x/player/playurl"
 	bf := NewBiliFrom(map[string]any{
 		"avid":  aid,
 		"cid":   cid,
-		"fnval": 16 | 128,
+		"fnval": fnval,
 		"fourk": 1,
 	})
 	var result PlayInfoStruct
@@ -304,6 +386,105 @@ func (ba *BApiClient) GetPlayURL(aid, cid int64) (PlayInfoStruct, error) {
 	}
 	return result, nil
 }
+
+// 获取 PGC 播放地址
+func (ba *BApiClient) GetPGCPlayURL(aid, cid, epid int64, fnval int) (PlayInfoStruct, error) {
+	api := "https://api.bilibili.com/pgc/player/web/playurl"
+	bf := NewBiliFrom(map[string]any{
+		"avid":  aid,
+		"cid":   cid,
+		"ep_id": epid,
+		"fnval": fnval,
+		"fourk": 1,
+	})
+	var result PlayInfoStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return PlayInfoStruct{}, err
+	}
+	return result, nil
+}
+
+// 获取课程播放地址, 未购买的付费课程返回错误
+func (ba *BApiClient) GetCoursePlayURL(aid, cid, epid int64, fnval int) (PlayInfoStruct, error) {
+	api := "https://api.bilibili.com/pugv/player/web/playurl"
+	bf := NewBiliFrom(map[string]any{
+		"avid":  aid,
+		"cid":   cid,
+		"ep_id": epid,
+		"fnval": fnval,
+		"fourk": 1,
+	})
+	var result PlayInfoStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return PlayInfoStruct{}, err
+	}
+	return result, nil
+}
+
+// 获取音频区歌曲信息
+func (ba *BApiClient) GetAudioInfo(sid int64) (AudioInfoStruct, error) {
+	api := "https://www.bilibili.com/audio/music-service-c/web/song/info"
+	bf := NewBiliFrom(map[string]any{
+		"sid": sid,
+	})
+	var result AudioInfoStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return AudioInfoStruct{}, err
+	}
+	return result, nil
+}
+
+// 获取音频区歌曲下载地址
+func (ba *BApiClient) GetAudioURL(sid int64) (DownloadUrls, error) {
+	api := "https://www.bilibili.com/audio/music-service-c/web/url"
+	bf := NewBiliFrom(map[string]any{
+		"sid":       sid,
+		"privilege": 2,
+		"quality":   2,
+	})
+	var result AudioURLStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return DownloadUrls{}, err
+	}
+	if len(result.Cdns) == 0 {
+		return DownloadUrls{}, fmt.Errorf("音频下载地址为空")
+	}
+	return DownloadUrls{Urls: result.Cdns}, nil
+}
+
+// 获取 PGC 剧集信息
+func (ba *BApiClient) GetPGCSeason(seasonID int64) (PGCSeasonStruct, error) {
+	api := "https://api.bilibili.com/pgc/view/web/season"
+	bf := NewBiliFrom(map[string]any{
+		"season_id": seasonID,
+	})
+	var result PGCSeasonStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return PGCSeasonStruct{}, err
+	}
+	return result, nil
+}
+
+// 获取课程信息, seasonID 为 0 时按 epID 查询所属课程
+func (ba *BApiClient) GetCourseSeason(seasonID, epID int64) (PGCSeasonStruct, error) {
+	api := "https://api.bilibili.com/pugv/view/web/season"
+	params := map[string]any{"season_id": seasonID}
+	if seasonID == 0 {
+		params = map[string]any{"ep_id": epID}
+	}
+	var result CourseSeasonStruct
+	err := ba.GET(api, NewBiliFrom(params), &result)
+	if err != nil {
+		return PGCSeasonStruct{}, err
+	}
+	return result.PGCSeason(), nil
+}
+
 func (ba *BApiClient) GetFavList(mid int) (FavListStruct, error) {
 	api := "https://api.bilibili.com/x/v3/fav/folder/created/list-all"
 	bf := NewBiliFrom(map[string]any{
@@ -335,35 +516,124 @@ func (ba *BApiClient) GetFavMediaList(fid, pn int) (FavMediaListStruct, error) {
 	return result, nil
 }
 
-var BApi *BApiClient
+// 获取收藏的收藏夹和合集
+func (ba *BApiClient) GetCollectedFavList(mid, pn int) (FavListStruct, error) {
+	api := "https://api.bilibili.com/x/v3/fav/folder/collected/list"
+	bf := NewBiliFrom(map[string]any{
+		"up_mid":   mid,
+		"pn":       pn,
+		"ps":       20,
+		"platform": "web",
+	})
+	var result FavListStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return FavListStruct{}, err
+	}
+	return result, nil
+}
 
-func init() {
-	BApi = NewBApiClient()
+// 获取合集中的投稿
+func (ba *BApiClient) GetSeasonMediaList(seasonID, pn int) (SeasonMediaListStruct, error) {
+	api := "https://api.bilibili.com/x/space/fav/season/list"
+	bf := NewBiliFrom(map[string]any{
+		"season_id": seasonID,
+		"pn":        pn,
+		"ps":        20,
+	})
+	var result SeasonMediaListStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return SeasonMediaListStruct{}, err
+	}
+	return result, nil
 }
 
-func CheckCookieFile(cfName string) (UserInfoStruct, bool) {
-	BApi.SetCookieFile(cfName)
-	var uf UserInfoStruct
-	uf, err := BApi.GetUserInfo()
+// 获取稍后再看列表
+func (ba *BApiClient) GetToViewList() (ToViewListStruct, error) {
+	api := "https://api.bilibili.com/x/v2/history/toview"
+	var result ToViewListStruct
+	err := ba.GET(api, nil, &result)
 	if err != nil {
-		log.Error().Err(err).Msg("获取用户信息失败")
-		return UserInfoStruct{}, false
+		return ToViewListStruct{}, err
 	}
-	return uf, true
+	return result, nil
 }
 
-func RefreshToken(cfName string) {
-	BApi.SetCookieFile(cfName)
-	cookieInfo, err := BApi.RefreshToken()
+// 获取最近点赞的视频
+func (ba *BApiClient) GetLikedVideoList(mid int) ([]ArcStruct, error) {
+	api := "https://api.bilibili.com/x/space/like/video"
+	bf := NewBiliFrom(map[string]any{
+		"vmid": mid,
+	})
+	var result struct {
+		List []ArcStruct `json:"list"`
+	}
+	err := ba.GET(api, bf, &result)
 	if err != nil {
-		log.Fatal().Err(err).Msg("刷新 cookie 失败")
-		// TODO 通知
-		msg := fmt.Sprintf("自动刷新 cookie 失败: %v 程序已退出", err)
-		SendNotification(GlobalConfig.Notification, msg, GlobalConfig.NotificationProxy)
-		return
+		return nil, err
+	}
+	return result.List, nil
+}
+
+// 获取最近投币的视频
+func (ba *BApiClient) GetCoinedVideoList(mid int) ([]ArcStruct, error) {
+	api := "https://api.bilibili.com/x/space/coin/video"
+	bf := NewBiliFrom(map[string]any{
+		"vmid": mid,
+	})
+	var result []ArcStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 获取历史记录, max 和 viewAt 为上一页返回的游标, 首页为 0
+func (ba *BApiClient) GetHistory(max int64, viewAt int) (HistoryListStruct, error) {
+	api := "https://api.bilibili.com/x/web-interface/history/cursor"
+	bf := NewBiliFrom(map[string]any{
+		"max":      max,
+		"view_at":  viewAt,
+		"business": "archive",
+		"ps":       30,
+	})
+	var result HistoryListStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return HistoryListStruct{}, err
 	}
-	cookieInfo.SaveToFile(cfName)
-	if uf, err := CheckCookieFile(cfName); err {
-		log.Info().Msgf("%s UID: %v Cookie 刷新成功", uf.Uname, uf.Mid)
+	return result, nil
+}
+
+// 获取用户名片信息
+func (ba *BApiClient) GetUserCard(mid int) (UserCardStruct, error) {
+	api := "https://api.bilibili.com/x/web-interface/card"
+	bf := NewBiliFrom(map[string]any{
+		"mid": mid,
+	})
+	var result UserCardStruct
+	err := ba.GET(api, bf, &result)
+	if err != nil {
+		return UserCardStruct{}, err
 	}
+	return result, nil
+}
+
+// 获取UP主投稿列表, 按发布时间倒序
+func (ba *BApiClient) GetSpaceVideoList(mid, pn int) (SpaceVideoListStruct, error) {
+	api := "https://api.bilibili.com/x/space/wbi/arc/search"
+	bf := NewBiliFrom(map[string]any{
+		"mid":   mid,
+		"order": "pubdate",
+		"ps":    30,
+		"pn":    pn,
+	})
+	var result SpaceVideoListStruct
+	err := ba.GET(api, bf, &result, true)
+	if err != nil {
+		return SpaceVideoListStruct{}, err
+	}
+	return result, nil
 }
@@ -12,6 +12,7 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -31,19 +32,28 @@ import (
 type ViewReq = viewapi.ViewReq
 type ViewReply = viewapi.ViewReply
 type DmSegMobileReq = dmapi.DmSegMobileReq
+type DmSegMobileReply = dmapi.DmSegMobileReply
 type DanmakuStruct = dmapi.DanmakuElem
 
-// RetryUnaryInterceptor 创建一个支持条件重试的gRPC一元拦截器
-func RetryUnaryInterceptor(maxRetries int, backoffDuration time.Duration) grpc.UnaryClientInterceptor {
+// RetryUnaryInterceptor 创建一个支持条件重试的gRPC一元拦截器, 每次请求前等待 limiter 限速
+func RetryUnaryInterceptor(limiter *RateLimiter, maxRetries int, backoffDuration time.Duration) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		var lastErr error
 		for attempt := 0; attempt <= maxRetries; attempt++ {
+			if err := limiter.Wait(ctx, method); err != nil {
+				return err
+			}
 			err := invoker(ctx, method, req, reply, cc, opts...)
 			log.Debug().Msgf("Hook Grpc : %s, req: %v, reply: %v, err: %v [%d/%d]", method, req, reply, err, attempt+1, maxRetries)
 			if err == nil {
 				return nil
 			}
 			lastErr = formatGRPCError(err)
+			// 触发风控时等待冷却, 不重试
+			if IsGRPCRiskError(err) {
+				limiter.TriggerRisk(method, lastErr)
+				return err
+			}
 
 			if attempt >= maxRetries {
 				return err
@@ -63,10 +73,13 @@ func RetryUnaryInterceptor(maxRetries int, backoffDuration time.Duration) grpc.U
 
 // InitGRPC 初始化B站GRPC客户端
 func (ba *BApiClient) InitGRPC() error {
-	addr := "grpc.biliapi.net:443"
+	addr, secure := ba.config.Endpoints.grpcTarget()
 	creds := grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
 		MinVersion: tls.VersionTLS12,
 	}))
+	if !secure {
+		creds = grpc.WithTransportCredentials(insecure.NewCredentials())
+	}
 	kacp := keepalive.ClientParameters{
 		Time:                10 * time.Second, // 每10秒发送ping帧保持连接活跃
 		Timeout:             10 * time.Second, // 等待pong响应的超时时间
@@ -75,7 +88,14 @@ func (ba *BApiClient) InitGRPC() error {
 	options := []grpc.DialOption{
 		creds,
 		grpc.WithKeepaliveParams(kacp),
-		grpc.WithUnaryInterceptor(RetryUnaryInterceptor(3, 1*time.Second)),
+		grpc.WithUnaryInterceptor(RetryUnaryInterceptor(ba.limiter, 3, 1*time.Second)),
+	}
+	if ba.config.Proxy.GRPC != "" {
+		dialer, err := proxyDialer(ba.config.Proxy.GRPC)
+		if err != nil {
+			return err
+		}
+		options = append(options, grpc.WithContextDialer(dialer))
 	}
 	conn, err := grpc.NewClient(addr, options...)
 	if err != nil {
@@ -150,7 +170,7 @@ func (ba *BApiClient) getGRPCMetadata() metadata.MD {
 // getGRPCContext 获取附带元数据的上下文
 func (ba *BApiClient) getGRPCContext() context.Context {
 	md := ba.getGRPCMetadata()
-	return metadata.NewOutgoingContext(context.Background(), md)
+	return metadata.NewOutgoingContext(ba.ctx, md)
 }
 
 // formatGRPCError 格式化GRPC错误
@@ -170,7 +190,7 @@ func formatGRPCError(err error) error {
 }
 
 // GetDanmaku 获取弹幕
-func (ba *BApiClient) GetDanmaku(req *dmapi.DmSegMobileReq) (*dmapi.DmSegMobileReply, error) {
+func (ba *BApiClient) GetDanmaku(req *DmSegMobileReq) (*DmSegMobileReply, error) {
 	resp, err := ba.dmClient.DmSegMobile(ba.getGRPCContext(), req)
 	if err != nil {
 		return nil, formatGRPCError(err)
@@ -179,7 +199,7 @@ func (ba *BApiClient) GetDanmaku(req *dmapi.DmSegMobileReq) (*dmapi.DmSegMobileR
 }
 
 // GetView 获取视频信息
-func (ba *BApiClient) GetView(req *viewapi.ViewReq) (*viewapi.ViewReply, error) {
+func (ba *BApiClient) GetView(req *ViewReq) (*ViewReply, error) {
 	resp, err := ba.viewClient.View(ba.getGRPCContext(), req)
 	if err != nil {
 		return nil, formatGRPCError(err)
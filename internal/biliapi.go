@@ -0,0 +1,89 @@
+package internal
+
+import "github.com/rs/zerolog/log"
+
+// 留档使用的B站接口, BApiClient 为默认实现
+// 每个账号持有自己的实现, 可以替换为模拟接口或同时使用多个客户端
+
+// 登录状态
+type UserAPI interface {
+	SetCookieFile(cookieFile string) error
+	GetUserInfo() (UserInfoStruct, error)
+	CheckToken() (TokenInfoStruct, error)
+	RefreshCookie() error
+	AutoRefreshCookie()
+}
+
+// 收藏夹及其他投稿来源
+type FavAPI interface {
+	GetFavList(mid int) (FavListStruct, error)
+	GetFavMediaList(fid, pn int) (FavMediaListStruct, error)
+	GetCollectedFavList(mid, pn int) (FavListStruct, error)
+	GetSeasonMediaList(seasonID, pn int) (SeasonMediaListStruct, error)
+	GetToViewList() (ToViewListStruct, error)
+	GetLikedVideoList(mid int) ([]ArcStruct, error)
+	GetCoinedVideoList(mid int) ([]ArcStruct, error)
+	GetHistory(max int64, viewAt int) (HistoryListStruct, error)
+	GetUserCard(mid int) (UserCardStruct, error)
+	GetSpaceVideoList(mid, pn int) (SpaceVideoListStruct, error)
+}
+
+// 投稿信息和播放地址
+type VideoAPI interface {
+	GetView(req *ViewReq) (*ViewReply, error)
+	GetPlayURL(aid, cid int64, fnval int) (PlayInfoStruct, error)
+	GetPGCPlayURL(aid, cid, epid int64, fnval int) (PlayInfoStruct, error)
+	GetPGCSeason(seasonID int64) (PGCSeasonStruct, error)
+	GetCoursePlayURL(aid, cid, epid int64, fnval int) (PlayInfoStruct, error)
+	GetCourseSeason(seasonID, epID int64) (PGCSeasonStruct, error)
+	GetAudioInfo(sid int64) (AudioInfoStruct, error)
+	GetAudioURL(sid int64) (DownloadUrls, error)
+}
+
+// 弹幕
+type DanmakuAPI interface {
+	GetDanmaku(req *DmSegMobileReq) (*DmSegMobileReply, error)
+}
+
+type BiliAPI interface {
+	UserAPI
+	FavAPI
+	VideoAPI
+	DanmakuAPI
+	InitGRPC() error
+	CloseGRPC() error
+}
+
+// 扫码登录
+type LoginAPI interface {
+	GetQRCode() (QRCodeStruct, error)
+	VerifyQrCode(qrcode QRCodeStruct) (CookieInfoStruct, error)
+	UserAPI
+}
+
+var (
+	_ BiliAPI  = (*BApiClient)(nil)
+	_ LoginAPI = (*BApiClient)(nil)
+)
+
+// 使用 cookie 文件获取用户信息, 检查登录状态
+func CheckCookieFile(api UserAPI, cfName string) (UserInfoStruct, bool) {
+	if err := api.SetCookieFile(cfName); err != nil {
+		log.Error().Err(err).Msg("读取 cookie 文件失败")
+		return UserInfoStruct{}, false
+	}
+	uf, err := api.GetUserInfo()
+	if err != nil {
+		log.Error().Err(err).Msg("获取用户信息失败")
+		return UserInfoStruct{}, false
+	}
+	return uf, true
+}
+
+// 刷新 cookie 文件
+func RefreshToken(api UserAPI, cfName string) {
+	api.SetCookieFile(cfName)
+	if err := api.RefreshCookie(); err != nil {
+		log.Fatal().Err(err).Msg("刷新 cookie 失败")
+	}
+}
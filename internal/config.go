@@ -7,28 +7,52 @@ import (
 )
 
 type Config struct {
-	User              string   `yaml:"user"`               // cookie文件路径
-	SavePath          string   `yaml:"save_path"`          // 投稿存储目录
-	PathTemplate      string   `yaml:"path_template"`      // 存储路径模板
-	Keywords          []string `yaml:"keywords"`           // 收藏夹关键词过滤
-	ScanInterval      int      `yaml:"scan_interval"`      // 扫描收藏夹间隔(分钟)
-	UpdateInterval    int      `yaml:"update_interval"`    // 更新元数据间隔(分钟)
-	UpdateDL          int      `yaml:"update_dl"`          // 停止更新元数据的天数
-	Incremental       bool     `yaml:"incremental"`        // 是否开启增量同步
-	Danmaku           bool     `yaml:"danmaku"`            // 是否下载弹幕
-	Notification      string   `yaml:"notification"`       // 通知配置
-	NotificationProxy string   `yaml:"notification_proxy"` // 通知代理
-	CustomScript      string   `yaml:"custom_script"`      // 自定义脚本
-	RunAfterUpdate    string   `yaml:"run_after_update"`   // 更新后运行脚本
-	DisablePCDN       bool     `yaml:"disable_pcdn"`       // 禁用PCDN下载视频
-	DownloadTaskConcurrency int    `yaml:"download_task_concurrency"` // 下载任务并发数
-	DownloadThreadConcurrency int    `yaml:"download_thread_concurrency"` // 单任务下载线程数
-	DownloadInterval int    `yaml:"download_interval"` // 下载间隔(秒)
-	DownloadIntervalRandom int	`yaml:"download_interval_random"` // 下载间隔随机偏移(秒)
-}
+	User                      string          `yaml:"user"`                        // cookie文件路径
+	SavePath                  string          `yaml:"save_path"`                   // 投稿存储目录
+	PathTemplate              string          `yaml:"path_template"`               // 存储路径模板
+	Keywords                  []string        `yaml:"keywords"`                    // 收藏夹关键词过滤
+	Collected                 bool            `yaml:"collected"`                   // 同步收藏的收藏夹和合集
+	Uppers                    []int           `yaml:"uppers"`                      // 监控的UP主 mid
+	ToView                    bool            `yaml:"toview"`                      // 同步稍后再看
+	ToViewMinHours            int             `yaml:"toview_min_hours"`            // 只留档加入稍后再看超过 N 小时的投稿
+	ToViewPathTemplate        string          `yaml:"toview_path_template"`        // 稍后再看的存储路径模板
+	Liked                     bool            `yaml:"liked"`                       // 同步点赞的视频
+	Coined                    bool            `yaml:"coined"`                      // 同步投币的视频
+	History                   bool            `yaml:"history"`                     // 同步历史记录
+	HistoryDays               int             `yaml:"history_days"`                // 历史记录回溯天数
+	ScanInterval              int             `yaml:"scan_interval"`               // 扫描收藏夹间隔(分钟)
+	UpdateInterval            int             `yaml:"update_interval"`             // 更新元数据间隔(分钟)
+	UpdateDL                  int             `yaml:"update_dl"`                   // 停止更新元数据的天数
+	Incremental               bool            `yaml:"incremental"`                 // 是否开启增量同步
+	Danmaku                   bool            `yaml:"danmaku"`                     // 是否下载弹幕
+	Notification              string          `yaml:"notification"`                // 通知配置
+	NotificationProxy         string          `yaml:"notification_proxy"`          // 通知代理
+	CustomScript              string          `yaml:"custom_script"`               // 自定义脚本
+	RunAfterUpdate            string          `yaml:"run_after_update"`            // 更新后运行脚本
+	DisablePCDN               bool            `yaml:"disable_pcdn"`                // 禁用PCDN下载视频
+	SkipPGC                   bool            `yaml:"skip_pgc"`                    // 跳过番剧/影视等 PGC 内容
+	AudioOnlyFolders          []string        `yaml:"audio_only_folders"`          // 只下载音频的收藏夹关键词
+	Filter                    VideoFilter     `yaml:"filter"`                      // 投稿过滤条件
+	Rules                     []FolderRule    `yaml:"rules"`                       // 收藏夹规则
+	Accounts                  []AccountConfig `yaml:"accounts"`                    // 多账号配置, 设置后忽略 user
+	DownloadTaskConcurrency   int             `yaml:"download_task_concurrency"`   // 下载任务并发数
+	DownloadThreadConcurrency int             `yaml:"download_thread_concurrency"` // 单任务下载线程数
+	DownloadInterval          int             `yaml:"download_interval"`           // 下载间隔(秒)
+	DownloadIntervalRandom    int             `yaml:"download_interval_random"`    // 下载间隔随机偏移(秒)
+	DBPath                    string          `yaml:"db_path"`                     // 留档状态数据库路径
+	DownloadRetry             int             `yaml:"download_retry"`              // 下载失败重试次数
+	DownloadRetryInterval     int             `yaml:"download_retry_interval"`     // 首次重试间隔(秒), 之后按指数增长
+	MaxQuality                string          `yaml:"max_quality"`                 // 最高画质 如 1080P60, 4K
+	Codecs                    []string        `yaml:"codecs"`                      // 视频编码优先级 avc/hevc/av1
+	CodecFirst                bool            `yaml:"codec_first"`                 // 优先保证编码, 其次画质
+	HiResAudio                bool            `yaml:"hires_audio"`                 // 优先下载 Hi-Res 无损/杜比全景声音轨
+	DedupeLink                string          `yaml:"dedupe_link"`                 // 重复投稿的链接方式 hardlink/symlink
+	RateLimit                 RateLimitConfig `yaml:"rate_limit"`                  // 接口限速和风控冷却
+	Proxy                     ProxyConfig     `yaml:"proxy"`                       // API、gRPC 和下载代理
+	Endpoints                 EndpointConfig  `yaml:"endpoints"`                   // 替换接口地址, 连接模拟服务器
 
-// 全局配置
-var GlobalConfig *Config
+	maxQualityQn int // 解析后的最高画质 qn
+}
 
 func LoadConfig(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -47,7 +71,13 @@ func LoadConfig(path string) (*Config, error) {
 		config.SavePath = "./videos"
 	}
 	if config.PathTemplate == "" {
-		config.PathTemplate = "{{ uname }}/{{ fav_name }}/{{ date }}-{{ video_title }}.{{ upper_name }}/{{ bv }}-P{{ pn }}"
+		config.PathTemplate = "{{ uname }}/{{ source }}/{{ date }}-{{ video_title }}.{{ upper_name }}/{{ bv }}-P{{ pn }}"
+	}
+	if config.ToViewPathTemplate == "" {
+		config.ToViewPathTemplate = "{{ uname }}/稍后再看/{{ date }}-{{ video_title }}.{{ upper_name }}/{{ bv }}-P{{ pn }}"
+	}
+	if config.HistoryDays <= 0 {
+		config.HistoryDays = 3 // 默认3天
 	}
 	if config.ScanInterval <= 0 {
 		config.ScanInterval = 10 // 默认10分钟
@@ -70,13 +100,77 @@ func LoadConfig(path string) (*Config, error) {
 	if config.DownloadThreadConcurrency <= 0 {
 		config.DownloadThreadConcurrency = 10 // 默认10线程
 	}
+	if config.DBPath == "" {
+		config.DBPath = "./archiver.db"
+	}
+	if config.DownloadRetry <= 0 {
+		config.DownloadRetry = 5 // 默认5次
+	}
+	if config.DownloadRetryInterval <= 0 {
+		config.DownloadRetryInterval = 60 // 默认1分钟
+	}
+	if config.MaxQuality == "" {
+		config.MaxQuality = "4K"
+	}
+	config.maxQualityQn, err = ParseQuality(config.MaxQuality)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Codecs) == 0 {
+		config.Codecs = []string{"avc", "hevc", "av1"}
+	}
+	if config.RateLimit.Rate <= 0 {
+		config.RateLimit.Rate = 2 // 默认每秒2次
+	}
+	if config.RateLimit.Burst <= 0 {
+		config.RateLimit.Burst = 5
+	}
+	if err := config.Proxy.validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Endpoints.validate(); err != nil {
+		return nil, err
+	}
+	if config.Proxy.HealthCheckURL == "" {
+		config.Proxy.HealthCheckURL = "https://upos-sz-mirrorcos.bilivideo.com/"
+	}
+	if config.Proxy.HealthCheckInterval <= 0 {
+		config.Proxy.HealthCheckInterval = 5 // 默认5分钟
+	}
+	if config.DedupeLink == "" {
+		config.DedupeLink = LinkModeHardlink
+	}
+	if config.DedupeLink != LinkModeHardlink && config.DedupeLink != LinkModeSymlink {
+		return nil, fmt.Errorf("dedupe_link 只能为 %s 或 %s: %s", LinkModeHardlink, LinkModeSymlink, config.DedupeLink)
+	}
+	if err := config.Filter.compile(); err != nil {
+		return nil, err
+	}
+	for i := range config.Rules {
+		if err := config.Rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range config.Accounts {
+		if err := config.Accounts[i].compile(); err != nil {
+			return nil, err
+		}
+	}
 
 	// 统一打印配置信息
 	fmt.Println("当前配置信息:")
 	fmt.Println("- cookie文件路径:", config.User)
+	for _, a := range config.Accounts {
+		fmt.Println("- 账号:", a.User, "存储目录:", a.SavePath)
+	}
 	fmt.Println("- 投稿存储目录:", config.SavePath)
 	fmt.Println("- 存储路径模板:", config.PathTemplate)
 	fmt.Println("- 收藏夹关键词过滤:", config.Keywords)
+	fmt.Println("- 同步收藏的收藏夹和合集:", config.Collected)
+	fmt.Println("- 监控的UP主:", config.Uppers)
+	fmt.Println("- 同步稍后再看:", config.ToView, "最短停留:", config.ToViewMinHours, "小时")
+	fmt.Println("- 稍后再看存储路径模板:", config.ToViewPathTemplate)
+	fmt.Println("- 同步点赞/投币/历史记录:", config.Liked, config.Coined, config.History, "历史记录回溯:", config.HistoryDays, "天")
 	fmt.Println("- 扫描收藏夹间隔:", config.ScanInterval, "分钟")
 	fmt.Println("- 更新元数据间隔:", config.UpdateInterval, "分钟")
 	fmt.Println("- 停止更新元数据的天数:", config.UpdateDL, "天")
@@ -87,12 +181,36 @@ func LoadConfig(path string) (*Config, error) {
 	fmt.Println("- 自定义脚本:", config.CustomScript)
 	fmt.Println("- 更新后运行脚本:", config.RunAfterUpdate)
 	fmt.Println("- 禁用PCDN下载视频:", config.DisablePCDN)
+	fmt.Println("- 跳过番剧/影视:", config.SkipPGC)
+	fmt.Println("- 只下载音频的收藏夹:", config.AudioOnlyFolders)
+	fmt.Println("- 投稿过滤条件:", config.Filter.Key())
+	fmt.Println("- 收藏夹规则:", len(config.Rules), "条")
 	fmt.Println("- 下载任务并发数:", config.DownloadTaskConcurrency)
 	fmt.Println("- 下载线程并发数:", config.DownloadThreadConcurrency)
+	fmt.Println("- 留档状态数据库:", config.DBPath)
+	fmt.Println("- 最高画质:", config.MaxQuality)
+	fmt.Println("- 视频编码优先级:", config.Codecs, "优先保证编码:", config.CodecFirst)
+	fmt.Println("- 优先下载 Hi-Res/杜比音轨:", config.HiResAudio)
+	fmt.Println("- 重复投稿链接方式:", config.DedupeLink)
+	fmt.Println("- API/gRPC 代理:", config.Proxy.API, config.Proxy.GRPC, "下载代理:", config.Proxy.Download)
+	if config.Endpoints != (EndpointConfig{}) {
+		fmt.Println("- 替换接口地址:", config.Endpoints.API, config.Endpoints.Passport, config.Endpoints.WWW, config.Endpoints.GRPC)
+	}
+	fmt.Println("- 接口限速:", config.RateLimit.Rate, "次/秒, 单独限速:", config.RateLimit.Endpoints)
+	fmt.Println("- 下载失败重试次数:", config.DownloadRetry, "次, 首次间隔", config.DownloadRetryInterval, "秒")
 	if config.DownloadInterval > 0 {
-		fmt.Println("- 下载间隔:", config.DownloadInterval - config.DownloadIntervalRandom, " ~ ", config.DownloadInterval + config.DownloadIntervalRandom, "秒")
+		fmt.Println("- 下载间隔:", config.DownloadInterval-config.DownloadIntervalRandom, " ~ ", config.DownloadInterval+config.DownloadIntervalRandom, "秒")
 	}
 
-	GlobalConfig = config
 	return config, nil
 }
+
+// 音视频流选择策略
+func (c *Config) StreamPolicy() StreamPolicy {
+	return StreamPolicy{
+		MaxQuality: c.maxQualityQn,
+		Codecs:     c.Codecs,
+		CodecFirst: c.CodecFirst,
+		HiResAudio: c.HiResAudio,
+	}
+}
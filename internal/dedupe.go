@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// 同一投稿出现在多个收藏夹或账号中时只下载一次
+// 已下载的内容按 BV+cid+画质 索引, 其他来源中以硬链接或软链接的方式保存
+
+const (
+	LinkModeHardlink = "hardlink"
+	LinkModeSymlink  = "symlink"
+)
+
+// 内容索引的 key, 只下载音频时以音质区分
+func (si StreamInfo) ContentKey(bvid string, cid int64) string {
+	quality := si.Quality
+	if si.Quality == 0 {
+		quality = si.AudioID
+	}
+	return fmt.Sprintf("%s:%d:%d:%s", bvid, cid, quality, si.Container)
+}
+
+// 在 dst 创建指向 src 的链接, 硬链接失败 (如跨文件系统) 时改用软链接
+func LinkFile(src, dst, mode string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	if mode != LinkModeSymlink {
+		err := os.Link(src, dst)
+		if err == nil {
+			return nil
+		}
+		log.Warn().Err(err).Msgf("创建硬链接失败, 改用软链接: %s", dst)
+	}
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(abs, dst)
+}
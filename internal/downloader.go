@@ -1,119 +1,370 @@
 package internal
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
+	"net/http"
 	"net/url"
 	"strings"
 
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strconv"
 
 	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
-	"github.com/imroc/req/v3"
 
 	"github.com/rs/zerolog/log"
 )
 
 type DownloadUrls struct {
-	Url       string // 主下载链接
-	BackupUrl string // 备用下载链接
+	Urls []string // 主下载链接及所有备用链接
+}
+
+// 链接是否已过期或为空 (deadline 参数)
+func (du DownloadUrls) Expired() bool {
+	if len(du.Urls) == 0 {
+		return true
+	}
+	parsedURL, err := url.Parse(du.Urls[0])
+	if err != nil {
+		return true
+	}
+	deadline, err := strconv.ParseInt(parsedURL.Query().Get("deadline"), 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Now().Unix() > deadline-60
 }
 
 type DownloadTask struct {
 	GroupID   string       // 任务组ID
+	Mid       int64        // 留档账号 mid, 重新获取播放地址时使用该账号
+	Aid       int64        // 稿件 avid
+	Cid       int64        // 分P cid
+	EpID      int64        // PGC ep_id, 非 PGC 内容为 0
+	Course    bool         // 课程, 使用课程接口重新获取播放地址
+	AudioID   int64        // 音频区 auid, 非音频区内容为 0
+	Tags      *AudioTags   // 只下载音频时写入的标签
 	Title     string       // 稿件标题（分p）
 	VideoUrls DownloadUrls // 视频下载链接
 	AudioUrls DownloadUrls // 音频下载链接
 	DirPath   string       // 保存路径
+	Policy    StreamPolicy // 音视频流选择策略, 重新获取播放地址时使用
+	Container string       // 封装格式 mp4/mkv
+	Duration  int          // 预期时长(秒), 用于完整性校验
+
+	ContentKey string // 内容索引的 key, 下载完成后记录到内容索引
+	Additional bool   // 分P已在其他来源以不同画质留档, 完成后只记录为链接文件
+}
+
+// 音频文件标签
+type AudioTags struct {
+	Title  string
+	Artist string
+	Album  string
+	Cover  string // 封面图片路径
+	Lyrics string
 }
 
+// 是否只下载音频
+func (t *DownloadTask) AudioOnly() bool {
+	return t.AudioID != 0 || t.Policy.AudioOnly
+}
+
+// 合并后的文件路径
+func (t *DownloadTask) OutPath() string {
+	if t.Container == "" {
+		return t.DirPath + ".mp4"
+	}
+	return t.DirPath + "." + t.Container
+}
+
+// 任务唯一标识
+func (t *DownloadTask) Key() string {
+	if t.Additional {
+		return fmt.Sprintf("%d:%d:%s", t.Aid, t.Cid, t.DirPath)
+	}
+	return fmt.Sprintf("%d:%d", t.Aid, t.Cid)
+}
+
+// 任务组完成结果
+type TaskGroupResult struct {
+	GroupID   string           `json:"group_id"`
+	Dir       string           `json:"dir"`       // 保存目录
+	Total     int              `json:"total"`     // 分P总数
+	Succeeded []int64          `json:"succeeded"` // 下载成功的分P cid
+	Failed    map[int64]string `json:"failed"`    // 下载失败的分P cid 及原因
+}
+
+// 任务组回调, 所有分P都有结果后根据成功数量调用其中一个
+type TaskGroupCallbacks struct {
+	OnSuccess func(result TaskGroupResult) // 全部成功
+	OnPartial func(result TaskGroupResult) // 部分失败
+	OnFailure func(result TaskGroupResult) // 全部失败
+}
+
+// 任务组中的分P, Label 用于在通知中列出失败的分P
+type TaskGroupPage struct {
+	Cid   int64  `json:"cid"`
+	Label string `json:"label"`
+}
+
+// 任务组信息, 与进度一起持久化, 程序重启后由所属账号重建回调
+type TaskGroupInfo struct {
+	ID         string          `json:"id"`
+	Mid        int64           `json:"mid"`         // 所属账号
+	Source     string          `json:"source"`      // 来源 key
+	SourceName string          `json:"source_name"` // 来源名称, 用于匹配收藏夹规则
+	Title      string          `json:"title"`
+	Header     string          `json:"header"` // 通知标题
+	Pages      []TaskGroupPage `json:"pages"`
+}
+
+// 根据任务组信息重建回调, 每个账号注册一个
+type TaskGroupRestorer func(ctx context.Context, info TaskGroupInfo) TaskGroupCallbacks
+
 type TaskGroup struct {
-	TotalTasks     int
-	CompletedTasks int
-	OnComplete     func(groupID, pdir string)
-	mutex          sync.Mutex
+	info      TaskGroupInfo
+	pending   map[int64]bool // 尚未有结果的分P cid
+	result    TaskGroupResult
+	callbacks TaskGroupCallbacks
+	mutex     sync.Mutex
+}
+
+// 持久化的任务组进度
+func (g *TaskGroup) state() TaskGroupState {
+	st := TaskGroupState{Info: g.info, Result: g.result}
+	for cid := range g.pending {
+		st.Pending = append(st.Pending, cid)
+	}
+	return st
 }
 
 type DownloaderManager struct {
-	taskChan    chan *DownloadTask
-	client      *req.Client
+	ctx         context.Context // 程序退出时停止调度并中断下载
+	config      *Config         // 下载并发、重试和代理设置
+	limiter     *RateLimiter    // 触发风控时暂停重新获取播放地址
+	running     sync.WaitGroup  // 正在执行的下载任务
+	taskChan    chan *QueuedTask
+	wakeup      chan struct{} // 有新任务时唤醒调度
+	proxies     *ProxyPool    // 下载出口, 各分块轮流使用
 	concurrency int
 	downloadSem chan struct{} // 用于限制并发下载任务数
 
 	taskGroups map[string]*TaskGroup
+	restorers  map[int64]TaskGroupRestorer // 各账号重建任务组回调的方法
 	groupMutex sync.RWMutex
+
+	inflight      map[string]bool // 正在下载的任务
+	inflightMutex sync.Mutex
+
+	ffprobe bool // 是否可以使用 ffprobe 校验合并后的文件
+
+	clients       map[int64]BiliAPI // 各账号的 API 客户端
+	defaultClient BiliAPI           // 第一个注册的账号, 用于没有账号信息的旧任务
+	clientMutex   sync.RWMutex
+
+	store *ArchiveStore // 下载队列和分P状态
 }
 
-func NewDownloaderManager() *DownloaderManager {
-	taskChan := make(chan *DownloadTask, GlobalConfig.DownloadTaskConcurrency)	// 任务队列长度与同时下载个数保持一致
-	client := req.C().SetCommonHeaders(
-		map[string]string{
-			"Referer": "https://www.bilibili.com/",
-		},
-	).SetCommonRetryCount(3).SetTimeout(10 * time.Second).SetCommonRetryFixedInterval(1 * time.Second).
-		SetCommonRetryCondition(func(resp *req.Response, err error) bool {
-			// 如果有网络错误或其他HTTP错误，进行重试
-			if err != nil {
-				log.Warn().Stack().Err(err).Msg("下载失败, 进行重试")
-				return true
-			}
+// 留档使用的下载管理器接口, DownloaderManager 为默认实现
+type Downloader interface {
+	RegisterClient(mid int64, client BiliAPI)
+	RegisterTaskGroup(info TaskGroupInfo, callbacks TaskGroupCallbacks)
+	RegisterGroupRestorer(mid int64, restore TaskGroupRestorer)
+	ReportTaskResult(groupID string, cid int64, pdir string, err error)
+	AddTask(task *DownloadTask)
+	Fetch(url string) ([]byte, error)
+	FetchFile(url, filePath string) error
+}
 
-			return false
-		})
+var _ Downloader = (*DownloaderManager)(nil)
+
+// 创建下载管理器, ctx 取消后不再开始新任务, 正在下载的任务保存进度后退出
+// limiter 应与各账号的 API 客户端共用, 触发风控时一起暂停, 为 nil 时不等待风控冷却
+func NewDownloaderManager(ctx context.Context, config *Config, limiter *RateLimiter, store *ArchiveStore) *DownloaderManager {
+	if limiter == nil {
+		limiter = NewRateLimiter(RateLimitConfig{})
+	}
+	taskChan := make(chan *QueuedTask, config.DownloadTaskConcurrency) // 任务队列长度与同时下载个数保持一致
+	proxies := NewProxyPool(config.Proxy.Download)
+	if len(config.Proxy.Download) > 0 {
+		go proxies.RunHealthCheck(ctx, config.Proxy.HealthCheckURL, time.Duration(config.Proxy.HealthCheckInterval)*time.Minute)
+	}
 	// p := mpb.New(mpb.WithRefreshRate(100 * time.Millisecond))
+	_, err := exec.LookPath("ffprobe")
+	if err != nil {
+		log.Warn().Msg("未找到 ffprobe, 将跳过合并后的完整性校验")
+	}
 	return &DownloaderManager{
+		ctx:         ctx,
+		config:      config,
+		limiter:     limiter,
 		taskChan:    taskChan,
-		client:      client,
-		concurrency: GlobalConfig.DownloadThreadConcurrency, // 每个任务的线程数
-		downloadSem: make(chan struct{}, GlobalConfig.DownloadTaskConcurrency),	// 同时下载个数
+		wakeup:      make(chan struct{}, 1),
+		proxies:     proxies,
+		concurrency: config.DownloadThreadConcurrency,                    // 每个任务的线程数
+		downloadSem: make(chan struct{}, config.DownloadTaskConcurrency), // 同时下载个数
 		taskGroups:  make(map[string]*TaskGroup),
+		restorers:   make(map[int64]TaskGroupRestorer),
+		inflight:    make(map[string]bool),
+		ffprobe:     err == nil,
+		clients:     make(map[int64]BiliAPI),
+		store:       store,
 	}
 }
 
-// 注册任务组并设置完成回调
-func (dm *DownloaderManager) RegisterTaskGroup(groupID string, taskCount int, callback func(string, string)) {
+// 注册账号的 API 客户端, 重新获取播放地址时使用任务所属账号的客户端
+func (dm *DownloaderManager) RegisterClient(mid int64, client BiliAPI) {
+	dm.clientMutex.Lock()
+	defer dm.clientMutex.Unlock()
+	dm.clients[mid] = client
+	if dm.defaultClient == nil {
+		dm.defaultClient = client
+	}
+}
+
+// 获取任务所属账号的客户端, 未注册时使用第一个注册的客户端
+func (dm *DownloaderManager) clientFor(task *DownloadTask) BiliAPI {
+	dm.clientMutex.RLock()
+	defer dm.clientMutex.RUnlock()
+	if client, ok := dm.clients[task.Mid]; ok {
+		return client
+	}
+	return dm.defaultClient
+}
+
+// 注册任务组并设置完成回调, info.Pages 为任务组包含的所有分P
+// 任务组进度保存到数据库, 程序重启后继续下载的任务仍能完成任务组
+func (dm *DownloaderManager) RegisterTaskGroup(info TaskGroupInfo, callbacks TaskGroupCallbacks) {
+	group := &TaskGroup{
+		info:    info,
+		pending: make(map[int64]bool),
+		result: TaskGroupResult{
+			GroupID: info.ID,
+			Total:   len(info.Pages),
+			Failed:  make(map[int64]string),
+		},
+		callbacks: callbacks,
+	}
+	for _, p := range info.Pages {
+		group.pending[p.Cid] = true
+	}
+
 	dm.groupMutex.Lock()
 	defer dm.groupMutex.Unlock()
+	dm.taskGroups[info.ID] = group
+	if err := dm.store.SaveTaskGroup(info.ID, group.state()); err != nil {
+		log.Error().Err(err).Msgf("保存任务组失败: %s", info.Title)
+	}
+}
 
-	dm.taskGroups[groupID] = &TaskGroup{
-		TotalTasks:     taskCount,
-		CompletedTasks: 0,
-		OnComplete:     callback,
+// 注册账号重建任务组回调的方法, 需要在下载管理器运行前注册
+func (dm *DownloaderManager) RegisterGroupRestorer(mid int64, restore TaskGroupRestorer) {
+	dm.groupMutex.Lock()
+	defer dm.groupMutex.Unlock()
+	dm.restorers[mid] = restore
+}
+
+// 获取任务组, 内存中没有时从数据库恢复并由所属账号重建回调
+func (dm *DownloaderManager) taskGroup(groupID string) (*TaskGroup, bool) {
+	dm.groupMutex.Lock()
+	defer dm.groupMutex.Unlock()
+	if group, ok := dm.taskGroups[groupID]; ok {
+		return group, true
+	}
+	st, ok := dm.store.GetTaskGroup(groupID)
+	if !ok {
+		return nil, false
+	}
+	group := &TaskGroup{
+		info:    st.Info,
+		pending: make(map[int64]bool),
+		result:  st.Result,
+	}
+	if group.result.Failed == nil {
+		group.result.Failed = make(map[int64]string)
+	}
+	for _, cid := range st.Pending {
+		group.pending[cid] = true
 	}
+	if restore, ok := dm.restorers[st.Info.Mid]; ok {
+		group.callbacks = restore(dm.ctx, st.Info)
+	} else {
+		log.Warn().Msgf("任务组所属账号未登录, 完成后不发送通知: %s", st.Info.Title)
+	}
+	dm.taskGroups[groupID] = group
+	log.Debug().Msgf("已恢复任务组: %s", st.Info.Title)
+	return group, true
 }
 
-// 通知任务组完成情况
-func (dm *DownloaderManager) notifyTaskGroupCompletion(groupID, pdir string) {
+// 报告任务组中某个分P的结果, err 不为空表示失败
+// 未进入下载队列的分P (如获取播放地址失败) 也需要报告, 否则任务组无法完成
+func (dm *DownloaderManager) ReportTaskResult(groupID string, cid int64, pdir string, err error) {
 	if groupID == "" {
 		return
 	}
 
-	dm.groupMutex.RLock()
-	group, exists := dm.taskGroups[groupID]
-	dm.groupMutex.RUnlock()
-
+	group, exists := dm.taskGroup(groupID)
 	if !exists {
 		return
 	}
 
 	group.mutex.Lock()
-	group.CompletedTasks++
-	completed := group.CompletedTasks >= group.TotalTasks
+	if !group.pending[cid] {
+		group.mutex.Unlock()
+		return
+	}
+	delete(group.pending, cid)
+	if group.result.Dir == "" {
+		group.result.Dir = pdir
+	}
+	if err != nil {
+		group.result.Failed[cid] = err.Error()
+	} else {
+		group.result.Succeeded = append(group.result.Succeeded, cid)
+	}
+	completed := len(group.pending) == 0
+	result := group.result
+	state := group.state()
 	group.mutex.Unlock()
 
-	if completed && group.OnComplete != nil {
-		// 所有任务已完成，调用回调并移除任务组
-		group.OnComplete(groupID, pdir)
+	if !completed {
+		if err := dm.store.SaveTaskGroup(groupID, state); err != nil {
+			log.Error().Err(err).Msgf("保存任务组进度失败: %s", group.info.Title)
+		}
+		return
+	}
 
-		dm.groupMutex.Lock()
-		delete(dm.taskGroups, groupID)
-		dm.groupMutex.Unlock()
+	// 所有分P都有结果，移除任务组并调用对应回调
+	dm.groupMutex.Lock()
+	delete(dm.taskGroups, groupID)
+	dm.groupMutex.Unlock()
+	if err := dm.store.DeleteTaskGroup(groupID); err != nil {
+		log.Error().Err(err).Msgf("删除任务组失败: %s", group.info.Title)
+	}
+
+	var callback func(TaskGroupResult)
+	switch {
+	case len(result.Failed) == 0:
+		callback = group.callbacks.OnSuccess
+	case len(result.Succeeded) == 0:
+		callback = group.callbacks.OnFailure
+	default:
+		callback = group.callbacks.OnPartial
+	}
+	if callback != nil {
+		callback(result)
 	}
 }
 
@@ -135,151 +386,549 @@ func (dm *DownloaderManager) replacePCDNHost(inputURL string) string {
 	return parsedURL.String()
 }
 
+// 禁用 PCDN 时替换为对应的镜像链接
+func (dm *DownloaderManager) normalizeUrl(url string) string {
+	if dm.config.DisablePCDN && strings.Contains(url, "mcdn.bilivideo.cn") {
+		url = dm.replacePCDNHost(url)
+		log.Warn().Msgf("已替换PCDN下载链接")
+	}
+	return url
+}
+
 // 使用 HEAD 测试 URL 是否有效 如果有效返回 文件大小
 func (dm *DownloaderManager) TestUrl(url string) (int64, int, bool) {
 	if url == "" {
 		return 0, 111, false
 	}
 
-	if GlobalConfig.DisablePCDN && strings.Contains(url, "mcdn.bilivideo.cn") {
-		url = dm.replacePCDNHost(url)
-		log.Warn().Msgf("已替换PCDN下载链接")
-	}
-
-	resp, err := dm.client.R().Head(url)
+	ctx, cancel := context.WithTimeout(dm.ctx, 10*time.Second)
+	defer cancel()
+	eg := dm.proxies.Pick()
+	resp, err := eg.client.R().
+		SetContext(ctx).
+		SetHeader("Referer", "https://www.bilibili.com/").
+		Head(url)
+	dm.reportProxy(eg, err)
 	if err != nil {
 		log.Error().Err(err).Msg("Head request failed")
 		return 0, 0, false
 	}
-	log.Debug().Msgf("%s  %d", url, resp.StatusCode)
-	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
-		return 0, resp.StatusCode, false
+	log.Debug().Msgf("%s  %d", url, resp.StatusCode())
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 400 {
+		return 0, resp.StatusCode(), false
 	}
-	contentLength := resp.ContentLength
-	return contentLength, resp.StatusCode, true
+	contentLength := resp.RawResponse.ContentLength
+	return contentLength, resp.StatusCode(), true
 }
 
-// 检查文件/目录是否存在 如果不存在则创建目录
-func (dm *DownloaderManager) CheckPath(path string) error {
-	dir := filepath.Dir(path)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			return err
+// 记录下载出口的请求结果, 程序退出或请求被取消导致的错误不计入出口的失败次数
+func (dm *DownloaderManager) reportProxy(eg *egress, err error) {
+	if err != nil && (dm.ctx.Err() != nil || errors.Is(err, context.Canceled)) {
+		return
+	}
+	dm.proxies.Report(eg, err)
+}
+
+// 通过下载出口获取封面、歌词等小文件, 与音视频流使用相同的代理
+func (dm *DownloaderManager) Fetch(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(dm.ctx, 30*time.Second)
+	defer cancel()
+	eg := dm.proxies.Pick()
+	resp, err := eg.client.R().
+		SetContext(ctx).
+		SetHeader("Referer", "https://www.bilibili.com/").
+		Get(url)
+	dm.reportProxy(eg, err)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("响应异常: %d", resp.StatusCode())
+	}
+	return resp.Body(), nil
+}
+
+// 获取小文件并保存到 filePath
+func (dm *DownloaderManager) FetchFile(url, filePath string) error {
+	data, err := dm.Fetch(url)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// 并发测试所有镜像链接, 返回文件大小和可用的链接 (以第一个可用链接的文件大小为准)
+func (dm *DownloaderManager) probeUrls(urls []string) (int64, []string, error) {
+	type probeResult struct {
+		url  string
+		size int64
+		code int
+		ok   bool
+	}
+	var normalized []string
+	for _, u := range urls {
+		if u = dm.normalizeUrl(u); !slices.Contains(normalized, u) {
+			normalized = append(normalized, u)
 		}
 	}
-	// 检查文件是否存在 如果存在就返回错误
-	if _, err := os.Stat(path); err == nil {
-		return os.ErrExist
+	results := make([]probeResult, len(normalized))
+	var wg sync.WaitGroup
+	for i, u := range normalized {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			size, code, ok := dm.TestUrl(u)
+			results[i] = probeResult{url: u, size: size, code: code, ok: ok}
+		}(i, u)
 	}
-	return nil
+	wg.Wait()
+
+	var size int64 = -1
+	var available []string
+	var codes []string
+	for _, r := range results {
+		if !r.ok || r.size <= 0 {
+			codes = append(codes, strconv.Itoa(r.code))
+			continue
+		}
+		if size < 0 {
+			size = r.size
+		}
+		if r.size == size {
+			available = append(available, r.url)
+		}
+	}
+	if len(available) == 0 {
+		return 0, nil, fmt.Errorf("Code : %s", strings.Join(codes, "/"))
+	}
+	log.Debug().Msgf("可用下载链接: %d/%d", len(available), len(normalized))
+	return size, available, nil
 }
 
+// 添加下载任务到持久化队列, 已在队列中的任务不会重复添加
 func (dm *DownloaderManager) AddTask(task *DownloadTask) {
-	dm.taskChan <- task
+	key := task.Key()
+	if qt, ok := dm.store.GetTask(key); ok && qt.Status != TaskStatusFailed {
+		log.Debug().Msgf("下载任务已在队列中: %s", task.Title)
+		return
+	}
+	qt := QueuedTask{
+		Task:      *task,
+		Status:    TaskStatusQueued,
+		NextRetry: int(time.Now().Unix()),
+		CreatedAt: int(time.Now().Unix()),
+	}
+	if err := dm.store.SaveTask(key, qt); err != nil {
+		log.Error().Err(err).Msgf("保存下载任务失败: %s", task.Title)
+		return
+	}
+	select {
+	case dm.wakeup <- struct{}{}:
+	default:
+	}
 }
 
+// 运行下载管理器, ctx 取消后等待正在下载的任务保存进度再返回
 func (dm *DownloaderManager) Run() {
 	log.Info().Msg("下载管理器已启动")
-	for task := range dm.taskChan {
-		// 获取信号量，限制最多5个任务同时下载
-		dm.downloadSem <- struct{}{}
-
-		videoPath := task.DirPath + ".mp4.1"
-		audioPath := task.DirPath + ".mp3.1"
-		outPath := task.DirPath + ".mp4"
-
-		var wg sync.WaitGroup
-		wg.Add(2)
-
-		videoDone := make(chan error, 1)
-		audioDone := make(chan error, 1)
-
-		go func() {
-			err := dm.download(task.VideoUrls, videoPath)
-			videoDone <- err
-			wg.Done()
-		}()
-
-		go func() {
-			err := dm.download(task.AudioUrls, audioPath)
-			audioDone <- err
-			wg.Done()
-		}()
-
-		go func() {
-			wg.Wait()
-			videoErr := <-videoDone
-			audioErr := <-audioDone
-
-			defer func() {
-				os.Remove(videoPath)
-				os.Remove(audioPath)
-				// 任务完成后释放信号量
-				<-dm.downloadSem
-				// log.Info().Msgf("删除临时文件: %s", task.Title)
-				// 无论下载成功还是失败，都通知任务组完成状态
-				dm.notifyTaskGroupCompletion(task.GroupID, filepath.Dir(outPath))
-			}()
-
-			if videoErr != nil || audioErr != nil {
-				log.Error().
-					Err(videoErr).
-					Msgf("视频或音频下载失败，无法合并: %s", task.Title)
-				return
+	go dm.schedule()
+	defer func() {
+		dm.running.Wait()
+		log.Info().Msg("下载管理器已停止")
+	}()
+	for {
+		var qt *QueuedTask
+		select {
+		case <-dm.ctx.Done():
+			return
+		case qt = <-dm.taskChan:
+		}
+		// 获取信号量，限制同时下载的任务数
+		select {
+		case <-dm.ctx.Done():
+			return
+		case dm.downloadSem <- struct{}{}:
+		}
+		dm.running.Add(1)
+		go dm.runTask(qt)
+	}
+}
+
+// 从持久化队列中取出到期的任务, 程序重启后未完成的任务会继续下载
+func (dm *DownloaderManager) schedule() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		tasks, err := dm.store.ListTasks()
+		if err != nil {
+			log.Error().Err(err).Msg("读取下载队列失败")
+		}
+		now := int(time.Now().Unix())
+		for i := range tasks {
+			qt := &tasks[i]
+			if qt.Status != TaskStatusQueued || qt.NextRetry > now {
+				continue
 			}
-			err := dm.merge(videoPath, audioPath, outPath)
-			if err != nil {
-				log.Error().Err(err).Msgf("合并失败: %s", task.Title)
+			if !dm.markInflight(qt.Task.Key()) {
+				continue
+			}
+			select {
+			case <-dm.ctx.Done():
 				return
+			case dm.taskChan <- qt:
+			}
+		}
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-dm.wakeup:
+		case <-ticker.C:
+		}
+	}
+}
+
+// 标记任务正在下载, 已在下载中时返回 false
+func (dm *DownloaderManager) markInflight(key string) bool {
+	dm.inflightMutex.Lock()
+	defer dm.inflightMutex.Unlock()
+	if dm.inflight[key] {
+		return false
+	}
+	dm.inflight[key] = true
+	return true
+}
+
+func (dm *DownloaderManager) clearInflight(key string) {
+	dm.inflightMutex.Lock()
+	defer dm.inflightMutex.Unlock()
+	delete(dm.inflight, key)
+}
+
+func (dm *DownloaderManager) runTask(qt *QueuedTask) {
+	task := &qt.Task
+	key := task.Key()
+	outPath := task.OutPath()
+	defer func() {
+		// 任务完成后释放信号量
+		<-dm.downloadSem
+		dm.clearInflight(key)
+		dm.running.Done()
+	}()
+
+	err := dm.downloadTask(qt)
+	if err != nil && dm.ctx.Err() != nil {
+		// 程序退出导致的中断不计入重试次数, 任务留在队列中, 下次启动时从断点继续
+		log.Info().Msgf("下载已中断, 下次启动时继续: %s", task.Title)
+		return
+	}
+	if err != nil {
+		qt.Attempts++
+		qt.LastError = err.Error()
+		if qt.Attempts >= dm.config.DownloadRetry {
+			// 超过重试次数, 标记为永久失败
+			qt.Status = TaskStatusFailed
+			log.Error().Err(err).Msgf("下载失败, 已重试 %d 次, 不再重试: %s", qt.Attempts, task.Title)
+			if !task.Additional {
+				dm.setPageStatus(task, PageStatusFailed, "", 0)
+			}
+			dm.ReportTaskResult(task.GroupID, task.Cid, filepath.Dir(outPath), err)
+			dm.resolveWaiting(task, "", err)
+		} else {
+			delay := dm.retryBackoff(qt.Attempts)
+			qt.NextRetry = int(time.Now().Add(delay).Unix())
+			log.Warn().Err(err).Msgf("下载失败, 将在 %s 后第 %d 次重试: %s", delay, qt.Attempts, task.Title)
+		}
+		if err := dm.store.SaveTask(key, *qt); err != nil {
+			log.Error().Err(err).Msgf("保存下载任务失败: %s", task.Title)
+		}
+		return
+	}
+
+	if err := dm.store.DeleteTask(key); err != nil {
+		log.Error().Err(err).Msgf("删除下载任务失败: %s", task.Title)
+	}
+	var size int64
+	if fi, err := os.Stat(outPath); err == nil {
+		size = fi.Size()
+	}
+	dm.finishContent(task, outPath, size)
+	dm.ReportTaskResult(task.GroupID, task.Cid, filepath.Dir(outPath), nil)
+	dm.resolveWaiting(task, outPath, nil)
+
+	if dm.config.DownloadInterval > 0 {
+		randomOffset := rand.Intn((2*dm.config.DownloadIntervalRandom)+1) - dm.config.DownloadIntervalRandom
+		delay := dm.config.DownloadInterval + randomOffset
+		log.Info().Msgf("下载间隔: 等待 %d 秒后继续下一个任务", delay)
+		SleepContext(dm.ctx, time.Duration(delay)*time.Second)
+	}
+}
+
+// 重试间隔按指数增长, 最长 6 小时
+func (dm *DownloaderManager) retryBackoff(attempts int) time.Duration {
+	delay := time.Duration(dm.config.DownloadRetryInterval) * time.Second
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, 6*time.Hour)
+}
+
+// 重新获取播放地址
+func (dm *DownloaderManager) refreshUrls(task *DownloadTask) error {
+	bapi := dm.clientFor(task)
+	if bapi == nil {
+		return fmt.Errorf("没有可用的 API 客户端")
+	}
+	if task.AudioID != 0 {
+		urls, err := bapi.GetAudioURL(task.AudioID)
+		if err != nil {
+			return fmt.Errorf("重新获取音频地址失败: %v", err)
+		}
+		task.AudioUrls = urls
+		log.Debug().Msgf("已重新获取音频地址: %s", task.Title)
+		return nil
+	}
+	var playInfo PlayInfoStruct
+	var err error
+	if task.Course {
+		playInfo, err = bapi.GetCoursePlayURL(task.Aid, task.Cid, task.EpID, task.Policy.Fnval())
+	} else if task.EpID != 0 {
+		playInfo, err = bapi.GetPGCPlayURL(task.Aid, task.Cid, task.EpID, task.Policy.Fnval())
+	} else {
+		playInfo, err = bapi.GetPlayURL(task.Aid, task.Cid, task.Policy.Fnval())
+	}
+	if err != nil {
+		return fmt.Errorf("重新获取播放地址失败: %v", err)
+	}
+	sel, err := playInfo.SelectStreams(task.Policy)
+	if err != nil {
+		return err
+	}
+	task.VideoUrls = sel.Video
+	task.AudioUrls = sel.Audio
+	task.Container = sel.Info.Container
+	task.Duration = sel.Info.Duration
+	err = dm.store.UpdateVideo(task.Aid, func(rec *VideoRecord) {
+		if p := rec.GetPage(task.Cid); p != nil {
+			p.Stream = sel.Info
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("记录音视频流信息失败: %s", task.Title)
+	}
+	log.Debug().Msgf("已重新获取播放地址: %s", task.Title)
+	return nil
+}
+
+// 下载音视频并合并, 失败时保留临时文件以便重试时断点续传
+func (dm *DownloaderManager) downloadTask(qt *QueuedTask) error {
+	task := &qt.Task
+	videoPath := task.DirPath + ".mp4.1"
+	audioPath := task.DirPath + ".mp3.1"
+
+	// 触发风控时暂停下载
+	if err := dm.limiter.WaitRisk(dm.ctx); err != nil {
+		return err
+	}
+	// 重试或链接过期时重新获取播放地址
+	if qt.Attempts > 0 || (!task.AudioOnly() && task.VideoUrls.Expired()) || task.AudioUrls.Expired() {
+		if err := dm.refreshUrls(task); err != nil {
+			return err
+		}
+	}
+
+	if task.AudioOnly() {
+		return dm.downloadAudioTask(task, audioPath)
+	}
+
+	var wg sync.WaitGroup
+	var videoErr, audioErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		videoErr = dm.download(task.VideoUrls, videoPath)
+	}()
+	go func() {
+		defer wg.Done()
+		audioErr = dm.download(task.AudioUrls, audioPath)
+	}()
+	wg.Wait()
+
+	if videoErr != nil || audioErr != nil {
+		log.Error().
+			Err(videoErr).
+			Msgf("视频或音频下载失败，无法合并: %s", task.Title)
+		if videoErr != nil {
+			return videoErr
+		}
+		return audioErr
+	}
+	outPath := task.OutPath()
+	err := dm.merge(videoPath, audioPath, outPath)
+	if dm.ctx.Err() != nil {
+		return dm.interrupted(outPath)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("合并失败: %s", task.Title)
+		return fmt.Errorf("合并失败: %v", err)
+	}
+	err = dm.verify(outPath, task.Duration, false)
+	if dm.ctx.Err() != nil {
+		return dm.interrupted(outPath)
+	}
+	if err != nil {
+		// 临时文件可能已损坏, 全部删除后重新下载
+		log.Error().Err(err).Msgf("完整性校验失败: %s", task.Title)
+		os.Remove(outPath)
+		removeTempFile(videoPath)
+		removeTempFile(audioPath)
+		return fmt.Errorf("完整性校验失败: %v", err)
+	}
+	log.Info().Msgf("下载并合并完成: %s", task.Title)
+	removeTempFile(videoPath)
+	removeTempFile(audioPath)
+	return nil
+}
+
+// 只下载音频并写入标签
+func (dm *DownloaderManager) downloadAudioTask(task *DownloadTask, audioPath string) error {
+	if err := dm.download(task.AudioUrls, audioPath); err != nil {
+		log.Error().Err(err).Msgf("音频下载失败: %s", task.Title)
+		return err
+	}
+	outPath := task.OutPath()
+	err := dm.mergeAudio(audioPath, outPath, task.Tags)
+	if dm.ctx.Err() != nil {
+		return dm.interrupted(outPath)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("写入音频标签失败: %s", task.Title)
+		return fmt.Errorf("写入音频标签失败: %v", err)
+	}
+	err = dm.verify(outPath, task.Duration, true)
+	if dm.ctx.Err() != nil {
+		return dm.interrupted(outPath)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("完整性校验失败: %s", task.Title)
+		os.Remove(outPath)
+		removeTempFile(audioPath)
+		return fmt.Errorf("完整性校验失败: %v", err)
+	}
+	log.Info().Msgf("音频下载完成: %s", task.Title)
+	removeTempFile(audioPath)
+	return nil
+}
+
+// 合并或校验被程序退出中断, 删除可能不完整的输出文件, 保留临时文件以便下次启动时重新合并
+func (dm *DownloaderManager) interrupted(outPath string) error {
+	os.Remove(outPath)
+	return dm.ctx.Err()
+}
+
+// 记录下载完成的内容
+func (dm *DownloaderManager) finishContent(task *DownloadTask, outPath string, size int64) {
+	if task.Additional {
+		err := dm.store.UpdateVideo(task.Aid, func(rec *VideoRecord) {
+			if p := rec.GetPage(task.Cid); p != nil {
+				p.AddLink(outPath)
 			}
-			log.Info().Msgf("下载并合并完成: %s", task.Title)
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("记录下载状态失败: %s", task.Title)
+		}
+	} else {
+		dm.setPageStatus(task, PageStatusDone, outPath, size)
+	}
+	if task.ContentKey != "" {
+		if err := dm.store.SaveContent(task.ContentKey, ContentRecord{File: outPath, Size: size}); err != nil {
+			log.Error().Err(err).Msgf("记录内容索引失败: %s", task.Title)
+		}
+	}
+}
 
-			if GlobalConfig.DownloadInterval > 0 {
-				randomOffset := rand.Intn((2 * GlobalConfig.DownloadIntervalRandom) + 1) - GlobalConfig.DownloadIntervalRandom
-				delay := GlobalConfig.DownloadInterval + randomOffset
-				log.Info().Msgf("下载间隔: 等待 %d 秒后继续下一个任务", delay)
-				time.Sleep(time.Duration(delay) * time.Second)
+// 为等待同一内容的其他来源创建链接, 下载失败时通知其任务组
+func (dm *DownloaderManager) resolveWaiting(task *DownloadTask, outPath string, taskErr error) {
+	if dm.store == nil || task.Additional {
+		return
+	}
+	var waiting []PendingLink
+	err := dm.store.UpdateVideo(task.Aid, func(rec *VideoRecord) {
+		p := rec.GetPage(task.Cid)
+		if p == nil {
+			return
+		}
+		waiting = p.Waiting
+		p.Waiting = nil
+		if taskErr != nil {
+			return
+		}
+		for _, w := range waiting {
+			p.AddLink(w.Path + filepath.Ext(outPath))
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("记录链接文件失败: %s", task.Title)
+		return
+	}
+	for _, w := range waiting {
+		err := taskErr
+		if err == nil {
+			err = LinkFile(outPath, w.Path+filepath.Ext(outPath), dm.config.DedupeLink)
+			if err == nil {
+				log.Info().Msgf("已链接到其他来源: %s", w.Path)
 			}
-		}()
+		}
+		dm.ReportTaskResult(w.GroupID, task.Cid, filepath.Dir(w.Path), err)
+	}
+}
+
+// 记录分P下载状态到留档状态数据库
+func (dm *DownloaderManager) setPageStatus(task *DownloadTask, status, file string, size int64) {
+	if dm.store == nil || task.Aid == 0 {
+		return
+	}
+	if err := dm.store.SetPageStatus(task.Aid, task.Cid, status, file, size); err != nil {
+		log.Error().Err(err).Msgf("记录下载状态失败: %s", task.Title)
 	}
 }
 
 func (dm *DownloaderManager) download(durl DownloadUrls, filePath string) error {
-	// 1) 分别对 url 和 backupURL 做一次 HEAD 检查
-	fileSize, rcode, urlOk := dm.TestUrl(durl.Url)
-	fileSize2, rcode2, backupUrlOk := dm.TestUrl(durl.BackupUrl)
+	// 1) 对所有镜像链接做一次 HEAD 检查
 	fileBaseName := filepath.Base(filePath)
-	if !urlOk && !backupUrlOk && fileSize != fileSize2 {
-		return fmt.Errorf("无效的下载链接: %s Code : %d/%d", fileBaseName, rcode, rcode2)
+	fileSize, urls, err := dm.probeUrls(durl.Urls)
+	if err != nil {
+		return fmt.Errorf("无效的下载链接: %s %v", fileBaseName, err)
 	}
 
-	if err := dm.CheckPath(filePath); err != nil {
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
 		return fmt.Errorf("保存路径检查失败: %s , %v", filePath, err)
 	}
 
-	log.Debug().Msgf("开始下载: %s [%d MB]", fileBaseName, fileSize/1024/1024)
-	f, err := os.Create(filePath)
+	// 读取进度日志, 临时文件已存在时从中断处继续下载
+	journal := loadJournal(filePath, fileSize)
+	defer journal.Save()
+	f, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return fmt.Errorf("创建文件失败: %s , %v", filePath, err)
 	}
 	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.Size() != fileSize {
+		journal.Done = nil
+	}
 	if err = f.Truncate(fileSize); err != nil {
 		return fmt.Errorf("创建文件失败: %s , %v", filePath, err)
 	}
+	if completed := journal.Completed(); completed > 0 {
+		log.Info().Msgf("断点续传: %s [%d/%d MB]", fileBaseName, completed/1024/1024, fileSize/1024/1024)
+	} else {
+		log.Debug().Msgf("开始下载: %s [%d MB]", fileBaseName, fileSize/1024/1024)
+	}
 
 	var downloadErr error
 	chunkSize := int64(math.Ceil(float64(fileSize) / float64(dm.concurrency)))
 	var wg sync.WaitGroup
 	errChan := make(chan error, dm.concurrency)
 
-	restyClient := resty.New().
-		SetRetryCount(3).
-		SetRetryWaitTime(1 * time.Second).
-		SetTimeout(10 * time.Second).AddRetryHook(func(resp *resty.Response, err error) {
-		log.Warn().Err(err).Msgf("分块下载请求失败: %s, 将在 1s 后重试", fileBaseName)
-	})
-
 	for i := range dm.concurrency {
 		start := int64(i) * chunkSize
 		end := start + chunkSize - 1
@@ -289,53 +938,43 @@ func (dm *DownloaderManager) download(durl DownloadUrls, filePath string) error
 		if start >= fileSize {
 			break
 		}
-		// 如果两个 url 都可用，就交替使用；如果只有一个可用，就只用那个
-		var url string
-		if urlOk && backupUrlOk {
-			if i%2 == 0 {
-				url = durl.Url
-			} else {
-				url = durl.BackupUrl
-			}
-		} else if urlOk {
-			url = durl.Url
-		} else if backupUrlOk {
-			url = durl.BackupUrl
-		}
 		wg.Add(1)
-		go func(start, end int64, dURL string) {
+		// 各分块从不同的镜像开始下载, 出错或停滞时切换到下一个镜像
+		go func(start, end int64, mirror int) {
 			defer wg.Done()
 
-			var resp *resty.Response
-			var err error
 			maxRetries := 3
-			resp, err = restyClient.R().
-				SetHeader("Referer", "https://www.bilibili.com/").
-				SetHeader("Range", fmt.Sprintf("bytes=%d-%d", start, end)).
-				SetDoNotParseResponse(true).
-				Get(dURL)
-			if err != nil {
-				errChan <- fmt.Errorf("分块下载失败(已重试%d次): %s, %v", maxRetries, fileBaseName, err)
-				return
-			}
-
-			defer resp.RawBody().Close()
-			buf := make([]byte, 1024*1024)
-			offset := start
-			for {
-				n, err := resp.RawBody().Read(buf)
-				if n > 0 {
-					if _, err = f.WriteAt(buf[:n], offset); err != nil {
-						errChan <- err
-						break
+			eg := dm.proxies.Pick() // 各分块分散到不同的下载出口
+			// 只下载分块中尚未完成的区间, 连接中断时从已写入的位置继续
+			for _, gap := range journal.Missing(start, end+1) {
+				offset := gap[0]
+				failures := 0
+				for offset < gap[1] {
+					n, err := dm.downloadRange(eg.client, urls[mirror], f, journal, offset, gap[1])
+					offset += n
+					dm.reportProxy(eg, err)
+					if err == nil {
+						continue
 					}
-					offset += int64(n)
-				}
-				if err != nil {
-					break
+					if n > 0 {
+						failures = 0
+					}
+					if dm.ctx.Err() != nil {
+						errChan <- fmt.Errorf("下载已中断: %s", fileBaseName)
+						return
+					}
+					failures++
+					if failures > maxRetries*len(urls) {
+						errChan <- fmt.Errorf("分块下载失败(已重试%d次): %s, %v", failures-1, fileBaseName, err)
+						return
+					}
+					mirror = (mirror + 1) % len(urls)
+					eg = dm.proxies.Pick()
+					log.Warn().Err(err).Msgf("分块下载中断: %s, 切换到镜像 %d/%d 从 %d 继续", fileBaseName, mirror+1, len(urls), offset)
+					SleepContext(dm.ctx, 1*time.Second)
 				}
 			}
-		}(start, end, url)
+		}(start, end, i%len(urls))
 	}
 
 	// 等待所有下载协程完成
@@ -344,23 +983,89 @@ func (dm *DownloaderManager) download(durl DownloadUrls, filePath string) error
 		close(errChan)
 	}()
 
-	// 检查是否有错误
+	// 等待所有分块结束后再保存进度日志, 记录第一个错误
 	for err := range errChan {
-		if err != nil {
+		if err != nil && downloadErr == nil {
 			downloadErr = err
-			break
 		}
 	}
 
 	if downloadErr != nil {
 		return downloadErr
 	}
+	// 校验下载的数据与 Content-Length 一致
+	if completed := journal.Completed(); completed != fileSize {
+		return fmt.Errorf("下载不完整: %s [%d/%d]", fileBaseName, completed, fileSize)
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != fileSize {
+		return fmt.Errorf("文件大小与 Content-Length 不一致: %s", fileBaseName)
+	}
 	log.Debug().Msgf("下载完成: %s", fileBaseName)
 	return nil
 }
 
+// 分块下载停滞超时
+const downloadStallTimeout = 15 * time.Second
+
+// 下载 [start, end) 区间并写入文件, 返回实际写入的字节数
+func (dm *DownloaderManager) downloadRange(client *resty.Client, dURL string, f *os.File, journal *downloadJournal, start, end int64) (int64, error) {
+	// 超过一定时间没有收到数据视为停滞, 取消请求以便切换镜像
+	ctx, cancel := context.WithCancel(dm.ctx)
+	defer cancel()
+	stall := time.AfterFunc(downloadStallTimeout, cancel)
+	defer stall.Stop()
+
+	resp, err := client.R().
+		SetContext(ctx).
+		SetHeader("Referer", "https://www.bilibili.com/").
+		SetHeader("Range", fmt.Sprintf("bytes=%d-%d", start, end-1)).
+		SetDoNotParseResponse(true).
+		Get(dURL)
+	if err != nil {
+		if ctx.Err() != nil && dm.ctx.Err() == nil {
+			err = fmt.Errorf("超过 %s 未收到响应", downloadStallTimeout)
+		}
+		return 0, err
+	}
+	defer resp.RawBody().Close()
+	// 服务器不支持 Range 时只能从文件开头写入
+	if resp.StatusCode() != http.StatusPartialContent && !(resp.StatusCode() == http.StatusOK && start == 0) {
+		return 0, fmt.Errorf("分块下载响应异常: %d", resp.StatusCode())
+	}
+
+	buf := make([]byte, 1024*1024)
+	offset := start
+	for offset < end {
+		n, err := resp.RawBody().Read(buf)
+		if n > 0 {
+			stall.Reset(downloadStallTimeout)
+			if int64(n) > end-offset {
+				n = int(end - offset)
+			}
+			if _, werr := f.WriteAt(buf[:n], offset); werr != nil {
+				return offset - start, werr
+			}
+			journal.Add(offset, offset+int64(n))
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if ctx.Err() != nil && dm.ctx.Err() == nil {
+				err = fmt.Errorf("超过 %s 未收到数据", downloadStallTimeout)
+			}
+			return offset - start, err
+		}
+	}
+	if offset < end {
+		return offset - start, fmt.Errorf("分块数据不完整: %d/%d", offset-start, end-start)
+	}
+	return offset - start, nil
+}
+
 func (dm *DownloaderManager) merge(videoPath, audioPath, outPath string) error {
-	cmd := exec.Command("ffmpeg",
+	cmd := exec.CommandContext(dm.ctx, "ffmpeg",
 		"-i", videoPath,
 		"-i", audioPath,
 		"-c:v", "copy",
@@ -371,4 +1076,83 @@ func (dm *DownloaderManager) merge(videoPath, audioPath, outPath string) error {
 	return cmd.Run()
 }
 
-var DM *DownloaderManager
+// 封装音频并写入标签和封面
+func (dm *DownloaderManager) mergeAudio(audioPath, outPath string, tags *AudioTags) error {
+	args := []string{"-i", audioPath}
+	if tags != nil && tags.Cover != "" {
+		if _, err := os.Stat(tags.Cover); err == nil {
+			args = append(args,
+				"-i", tags.Cover,
+				"-map", "0:a",
+				"-map", "1:v",
+				"-c:v", "copy",
+				"-disposition:v", "attached_pic",
+			)
+		}
+	}
+	args = append(args, "-c:a", "copy")
+	if tags != nil {
+		for _, kv := range [][2]string{
+			{"title", tags.Title},
+			{"artist", tags.Artist},
+			{"album", tags.Album},
+			{"lyrics", tags.Lyrics},
+		} {
+			if kv[1] != "" {
+				args = append(args, "-metadata", kv[0]+"="+kv[1])
+			}
+		}
+	}
+	args = append(args, "-y", outPath)
+	return exec.CommandContext(dm.ctx, "ffmpeg", args...).Run()
+}
+
+// ffprobe 输出
+type probeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// 校验合并后的文件: 音视频流都存在 (只下载音频时不要求视频流), 时长与播放信息一致 (允许 2 秒或 2% 的误差)
+func (dm *DownloaderManager) verify(outPath string, duration int, audioOnly bool) error {
+	if !dm.ffprobe {
+		return nil
+	}
+	out, err := exec.CommandContext(dm.ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration:stream=codec_type",
+		"-of", "json",
+		outPath,
+	).Output()
+	if err != nil {
+		return fmt.Errorf("ffprobe 执行失败: %v", err)
+	}
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return fmt.Errorf("解析 ffprobe 输出失败: %v", err)
+	}
+	var hasVideo, hasAudio bool
+	for _, st := range probe.Streams {
+		switch st.CodecType {
+		case "video":
+			hasVideo = true
+		case "audio":
+			hasAudio = true
+		}
+	}
+	if (!hasVideo && !audioOnly) || !hasAudio {
+		return fmt.Errorf("缺少音视频流 (视频: %v, 音频: %v)", hasVideo, hasAudio)
+	}
+	if duration > 0 {
+		actual, err := strconv.ParseFloat(probe.Format.Duration, 64)
+		tolerance := math.Max(2, float64(duration)*0.02)
+		if err != nil || math.Abs(actual-float64(duration)) > tolerance {
+			return fmt.Errorf("时长不一致: %.1f 秒, 预期 %d 秒", actual, duration)
+		}
+	}
+	return nil
+}
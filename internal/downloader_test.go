@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+var testDownloadConfig = &Config{DownloadTaskConcurrency: 1, DownloadThreadConcurrency: 1}
+
+func TestTaskGroupRestoredAfterRestart(t *testing.T) {
+	store, err := OpenArchiveStore(filepath.Join(t.TempDir(), "archiver.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	info := TaskGroupInfo{
+		ID:     "BV1@1:fav:7",
+		Mid:    1,
+		Source: "1:fav:7",
+		Title:  "投稿",
+		Pages:  []TaskGroupPage{{Cid: 10, Label: "P1"}, {Cid: 20, Label: "P2"}},
+	}
+	dm := NewDownloaderManager(context.Background(), testDownloadConfig, nil, store)
+	dm.RegisterTaskGroup(info, TaskGroupCallbacks{})
+	dm.ReportTaskResult(info.ID, 10, "/videos", nil)
+
+	// 重启后由所属账号重建回调, 剩余分P的结果完成任务组
+	var restored TaskGroupInfo
+	var result *TaskGroupResult
+	dm = NewDownloaderManager(context.Background(), testDownloadConfig, nil, store)
+	dm.RegisterGroupRestorer(1, func(ctx context.Context, info TaskGroupInfo) TaskGroupCallbacks {
+		restored = info
+		return TaskGroupCallbacks{OnPartial: func(r TaskGroupResult) { result = &r }}
+	})
+	dm.ReportTaskResult(info.ID, 20, "/videos", errors.New("合并失败"))
+
+	if restored.Title != info.Title || len(restored.Pages) != 2 {
+		t.Fatalf("恢复的任务组信息 = %+v", restored)
+	}
+	if result == nil || result.Total != 2 || len(result.Succeeded) != 1 || result.Failed[20] != "合并失败" {
+		t.Fatalf("任务组结果 = %+v", result)
+	}
+	if _, ok := store.GetTaskGroup(info.ID); ok {
+		t.Fatal("完成的任务组没有从数据库删除")
+	}
+}
+
+func TestCanceledRequestsNotCountedAsProxyFailures(t *testing.T) {
+	store, err := OpenArchiveStore(filepath.Join(t.TempDir(), "archiver.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dm := NewDownloaderManager(ctx, testDownloadConfig, nil, store)
+	eg := dm.proxies.Pick()
+	dm.reportProxy(eg, fmt.Errorf("读取响应失败: %w", context.Canceled))
+	if n := eg.failures.Load(); n != 0 {
+		t.Fatalf("请求取消后出口失败次数 = %d, 预期 0", n)
+	}
+	// 程序退出后的超时等错误也不计入
+	cancel()
+	dm.reportProxy(eg, context.DeadlineExceeded)
+	if n := eg.failures.Load(); n != 0 {
+		t.Fatalf("程序退出后出口失败次数 = %d, 预期 0", n)
+	}
+	dm.ctx = context.Background()
+	dm.reportProxy(eg, errors.New("connection reset"))
+	if n := eg.failures.Load(); n != 1 {
+		t.Fatalf("出口失败次数 = %d, 预期 1", n)
+	}
+}
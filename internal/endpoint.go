@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// 替换B站接口地址, 用于连接模拟服务器离线运行 (见 mock 包)
+// 未设置的项使用B站的默认地址
+
+const defaultGRPCAddr = "grpc.biliapi.net:443"
+
+type EndpointConfig struct {
+	API      string `yaml:"api"`      // 替换 https://api.bilibili.com
+	Passport string `yaml:"passport"` // 替换 https://passport.bilibili.com
+	WWW      string `yaml:"www"`      // 替换 https://www.bilibili.com (音频接口)
+	GRPC     string `yaml:"grpc"`     // 替换 grpc.biliapi.net:443, 以 http:// 开头时不使用 TLS
+}
+
+// 检查接口地址配置
+func (ec *EndpointConfig) validate() error {
+	for _, e := range []string{ec.API, ec.Passport, ec.WWW, ec.GRPC} {
+		if e == "" {
+			continue
+		}
+		u, err := url.Parse(e)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("接口地址无效: %s", e)
+		}
+	}
+	return nil
+}
+
+// 替换接口链接中的 scheme 和 host
+func (ec *EndpointConfig) rewrite(api string) string {
+	u, err := url.Parse(api)
+	if err != nil {
+		return api
+	}
+	var base string
+	switch u.Host {
+	case "api.bilibili.com":
+		base = ec.API
+	case "passport.bilibili.com":
+		base = ec.Passport
+	case "www.bilibili.com":
+		base = ec.WWW
+	}
+	if base == "" {
+		return api
+	}
+	return strings.TrimSuffix(base, "/") + u.RequestURI()
+}
+
+// gRPC 服务地址, 以及是否使用 TLS
+func (ec *EndpointConfig) grpcTarget() (string, bool) {
+	if ec.GRPC == "" {
+		return defaultGRPCAddr, true
+	}
+	u, _ := url.Parse(ec.GRPC)
+	host := u.Host
+	if u.Port() == "" {
+		port := "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+		host += ":" + port
+	}
+	return host, u.Scheme == "https"
+}
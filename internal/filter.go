@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// 投稿过滤条件, 只对普通投稿生效, 不满足条件的投稿跳过并记录到留档状态数据库
+type VideoFilter struct {
+	MinDuration   int     `yaml:"min_duration"`   // 最短时长 (秒), 0 为不限制
+	MaxDuration   int     `yaml:"max_duration"`   // 最长时长 (秒), 0 为不限制
+	Uppers        []int64 `yaml:"uppers"`         // 只留档这些UP主的投稿
+	ExcludeUppers []int64 `yaml:"exclude_uppers"` // 不留档这些UP主的投稿
+	TypeIDs       []int   `yaml:"type_ids"`       // 只留档这些分区的投稿
+	ExcludeTypes  []int   `yaml:"exclude_types"`  // 不留档这些分区的投稿
+	PubAfter      string  `yaml:"pub_after"`      // 只留档此日期之后发布的投稿, 格式 2006-01-02
+	PubBefore     string  `yaml:"pub_before"`     // 只留档此日期之前发布的投稿
+
+	pubAfter  int
+	pubBefore int
+}
+
+// 检查并解析发布日期
+func (f *VideoFilter) compile() error {
+	var err error
+	if f.pubAfter, err = parseFilterDate(f.PubAfter); err != nil {
+		return err
+	}
+	if f.pubBefore, err = parseFilterDate(f.PubBefore); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseFilterDate(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return 0, fmt.Errorf("过滤条件日期无效: %s", s)
+	}
+	return int(t.Unix()), nil
+}
+
+// 过滤条件的标识, 过滤条件改变后重新检查已跳过的投稿
+func (f *VideoFilter) Key() string {
+	return fmt.Sprintf("%d-%d|%v|%v|%v|%v|%s-%s", f.MinDuration, f.MaxDuration,
+		f.Uppers, f.ExcludeUppers, f.TypeIDs, f.ExcludeTypes, f.PubAfter, f.PubBefore)
+}
+
+// 用收藏夹列表中的信息检查投稿, 返回跳过原因, 为空时不跳过
+// 列表中没有的信息 (值为 0) 不检查, 获取投稿详情后补全再检查
+func (f *VideoFilter) CheckMedia(media FavMediaStruct) string {
+	if f.MinDuration > 0 && media.Duration != 0 && media.Duration < f.MinDuration {
+		return fmt.Sprintf("时长 %d 秒短于 %d 秒", media.Duration, f.MinDuration)
+	}
+	if f.MaxDuration > 0 && media.Duration > f.MaxDuration {
+		return fmt.Sprintf("时长 %d 秒超过 %d 秒", media.Duration, f.MaxDuration)
+	}
+	mid := int64(media.Upper.Mid)
+	if len(f.Uppers) != 0 && mid != 0 && !slices.Contains(f.Uppers, mid) {
+		return fmt.Sprintf("UP主 %s(%d) 不在留档列表中", media.Upper.Name, mid)
+	}
+	if slices.Contains(f.ExcludeUppers, mid) {
+		return fmt.Sprintf("UP主 %s(%d) 在排除列表中", media.Upper.Name, mid)
+	}
+	if f.pubAfter != 0 && media.Pubtime != 0 && media.Pubtime < f.pubAfter {
+		return fmt.Sprintf("发布于 %s, 早于 %s", FormatDate(media.Pubtime), f.PubAfter)
+	}
+	if f.pubBefore != 0 && media.Pubtime >= f.pubBefore {
+		return fmt.Sprintf("发布于 %s, 晚于 %s", FormatDate(media.Pubtime), f.PubBefore)
+	}
+	return ""
+}
+
+// 用投稿详情中的分区检查投稿, 返回跳过原因, 为空时不跳过
+func (f *VideoFilter) CheckType(typeID int) string {
+	if len(f.TypeIDs) != 0 && !slices.Contains(f.TypeIDs, typeID) {
+		return fmt.Sprintf("分区 %d 不在留档列表中", typeID)
+	}
+	if slices.Contains(f.ExcludeTypes, typeID) {
+		return fmt.Sprintf("分区 %d 在排除列表中", typeID)
+	}
+	return ""
+}
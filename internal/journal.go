@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 分块下载进度日志, 与临时文件放在一起 (<临时文件>.journal)
+// 记录已写入的字节区间, 下载中断或程序重启后只需下载缺失的部分
+
+type downloadJournal struct {
+	path     string
+	Size     int64      `json:"size"` // 文件总大小
+	Done     [][2]int64 `json:"done"` // 已完成区间 [start, end)
+	mutex    sync.Mutex
+	lastSave time.Time
+}
+
+func journalPath(filePath string) string {
+	return filePath + ".journal"
+}
+
+// 读取进度日志, 文件大小不一致时视为重新下载
+func loadJournal(filePath string, size int64) *downloadJournal {
+	j := &downloadJournal{path: journalPath(filePath), Size: size}
+	data, err := os.ReadFile(j.path)
+	if err != nil {
+		return j
+	}
+	var old downloadJournal
+	if err := json.Unmarshal(data, &old); err != nil || old.Size != size {
+		return j
+	}
+	j.Done = old.Done
+	return j
+}
+
+// 已完成的字节数
+func (j *downloadJournal) Completed() int64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	var n int64
+	for _, r := range j.Done {
+		n += r[1] - r[0]
+	}
+	return n
+}
+
+// 获取 [start, end) 中尚未完成的区间
+func (j *downloadJournal) Missing(start, end int64) [][2]int64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	var gaps [][2]int64
+	cur := start
+	for _, r := range j.Done {
+		if r[1] <= cur {
+			continue
+		}
+		if r[0] >= end {
+			break
+		}
+		if r[0] > cur {
+			gaps = append(gaps, [2]int64{cur, r[0]})
+		}
+		cur = r[1]
+	}
+	if cur < end {
+		gaps = append(gaps, [2]int64{cur, end})
+	}
+	return gaps
+}
+
+// 记录已完成的区间并合并相邻区间, 每秒最多写盘一次
+func (j *downloadJournal) Add(start, end int64) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.Done = append(j.Done, [2]int64{start, end})
+	sort.Slice(j.Done, func(a, b int) bool { return j.Done[a][0] < j.Done[b][0] })
+	merged := j.Done[:1]
+	for _, r := range j.Done[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	j.Done = merged
+	if time.Since(j.lastSave) >= time.Second {
+		j.save()
+	}
+}
+
+// 写入进度日志
+func (j *downloadJournal) Save() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.save()
+}
+
+func (j *downloadJournal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	j.lastSave = time.Now()
+	return os.Rename(tmp, j.path)
+}
+
+// 删除临时文件及其进度日志
+func removeTempFile(filePath string) {
+	os.Remove(filePath)
+	os.Remove(journalPath(filePath))
+}
@@ -12,6 +12,9 @@ type LoggerWrapper struct {
 	logger zerolog.Logger
 }
 
+// debug 模式下 API 客户端使用的请求日志, 之后创建的客户端同样开启
+var reqDevLogger *LoggerWrapper
+
 func (l *LoggerWrapper) Errorf(format string, v ...any) {
 	l.logger.Error().Msgf(format, v...)
 }
@@ -53,7 +56,7 @@ func InitLogger(debug bool) {
 	// 根据debug参数设置日志级别
 	if debug {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-		BApi.SetDev(reqLogger)
+		reqDevLogger = reqLogger
 	} else {
 		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	}
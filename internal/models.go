@@ -1,6 +1,9 @@
 package internal
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 type BiliErr struct {
 	Code    int    `json:"code"`
@@ -15,6 +18,7 @@ type BiliResp struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	Data    any    `json:"data"`
+	Result  any    `json:"result"` // PGC 接口使用 result 返回数据
 }
 
 type QRCodeStruct struct {
@@ -74,7 +78,13 @@ type FavListStruct struct {
 		Title      string `json:"title"`
 		FavState   int    `json:"fav_state"`
 		MediaCount int    `json:"media_count"`
+		Type       int    `json:"type"` // 收藏的收藏夹为 11, 合集为 21
+		Upper      struct {
+			Mid  int64  `json:"mid"`
+			Name string `json:"name"`
+		} `json:"upper"`
 	} `json:"list"`
+	HasMore bool `json:"has_more"`
 }
 
 type FavMediaListStruct struct {
@@ -138,20 +148,261 @@ type FavMediaStruct struct {
 		Reply      int    `json:"reply"`
 		ViewText1  string `json:"view_text_1"`
 	} `json:"cnt_info"`
-	Link    string `json:"link"`
-	Ctime   int    `json:"ctime"`
-	Pubtime int    `json:"pubtime"`
-	FavTime int    `json:"fav_time"`
-	BvID    string `json:"bv_id"`
-	Bvid    string `json:"bvid"`
-	Season  any    `json:"season"`
-	Ogv     any    `json:"ogv"`
+	Link    string        `json:"link"`
+	Ctime   int           `json:"ctime"`
+	Pubtime int           `json:"pubtime"`
+	FavTime int           `json:"fav_time"`
+	BvID    string        `json:"bv_id"`
+	Bvid    string        `json:"bvid"`
+	Season  any           `json:"season"`
+	Ogv     *FavOgvStruct `json:"ogv"` // PGC 内容 (番剧/影视)
 	Ugc     struct {
 		FirstCid int64 `json:"first_cid"`
 	} `json:"ugc"`
 	MediaListLink string `json:"media_list_link"`
 }
 
+type SeasonMediaListStruct struct {
+	Info struct {
+		ID         int    `json:"id"`
+		SeasonType int    `json:"season_type"`
+		Title      string `json:"title"`
+		Cover      string `json:"cover"`
+		Upper      struct {
+			Mid  int64  `json:"mid"`
+			Name string `json:"name"`
+		} `json:"upper"`
+		MediaCount int `json:"media_count"`
+	} `json:"info"`
+	Medias []FavMediaStruct `json:"medias"`
+}
+
+type ToViewListStruct struct {
+	Count int `json:"count"`
+	List  []struct {
+		Aid      int64  `json:"aid"`
+		Bvid     string `json:"bvid"`
+		Title    string `json:"title"`
+		Videos   int    `json:"videos"` // 分P数量
+		Pubdate  int    `json:"pubdate"`
+		Duration int    `json:"duration"` // 时长(秒)
+		Cid      int64  `json:"cid"`
+		AddAt    int    `json:"add_at"` // 加入稍后再看的时间
+		Owner    struct {
+			Mid  int64  `json:"mid"`
+			Name string `json:"name"`
+		} `json:"owner"`
+	} `json:"list"`
+}
+
+// 点赞、投币列表中的投稿
+type ArcStruct struct {
+	Aid      int64  `json:"aid"`
+	Bvid     string `json:"bvid"`
+	Title    string `json:"title"`
+	Videos   int    `json:"videos"` // 分P数量
+	Pubdate  int    `json:"pubdate"`
+	Duration int    `json:"duration"` // 时长(秒)
+	Cid      int64  `json:"cid"`
+	Owner    struct {
+		Mid  int64  `json:"mid"`
+		Name string `json:"name"`
+	} `json:"owner"`
+}
+
+// 转换为收藏夹投稿, favTime 作为断点和路径中的日期
+func (arc ArcStruct) FavMedia(favTime int) FavMediaStruct {
+	media := FavMediaStruct{
+		ID:       arc.Aid,
+		Type:     2,
+		Title:    arc.Title,
+		Page:     arc.Videos,
+		Duration: arc.Duration,
+		Bvid:     arc.Bvid,
+		Pubtime:  arc.Pubdate,
+		FavTime:  favTime,
+	}
+	media.Upper.Mid = int(arc.Owner.Mid)
+	media.Upper.Name = arc.Owner.Name
+	media.Ugc.FirstCid = arc.Cid
+	return media
+}
+
+type HistoryListStruct struct {
+	Cursor struct {
+		Max    int64 `json:"max"`
+		ViewAt int   `json:"view_at"`
+	} `json:"cursor"`
+	List []struct {
+		Title      string `json:"title"`
+		Videos     int    `json:"videos"`
+		Duration   int    `json:"duration"` // 时长(秒)
+		AuthorName string `json:"author_name"`
+		AuthorMid  int64  `json:"author_mid"`
+		ViewAt     int    `json:"view_at"`
+		History    struct {
+			Oid      int64  `json:"oid"`
+			Bvid     string `json:"bvid"`
+			Cid      int64  `json:"cid"`
+			Page     int    `json:"page"`
+			Business string `json:"business"`
+		} `json:"history"`
+	} `json:"list"`
+}
+
+type UserCardStruct struct {
+	Card struct {
+		Mid  string `json:"mid"`
+		Name string `json:"name"`
+		Face string `json:"face"`
+	} `json:"card"`
+}
+
+type SpaceVideoListStruct struct {
+	List struct {
+		Vlist []struct {
+			Aid     int64  `json:"aid"`
+			Bvid    string `json:"bvid"`
+			Title   string `json:"title"`
+			Author  string `json:"author"`
+			Mid     int64  `json:"mid"`
+			Created int    `json:"created"`
+			Length  string `json:"length"` // 时长, 如 12:34
+		} `json:"vlist"`
+	} `json:"list"`
+	Page struct {
+		Pn    int `json:"pn"`
+		Ps    int `json:"ps"`
+		Count int `json:"count"`
+	} `json:"page"`
+}
+
+type FavOgvStruct struct {
+	TypeName string `json:"type_name"`
+	TypeID   int    `json:"type_id"`
+	SeasonID int64  `json:"season_id"`
+}
+
+// PGC 剧集信息
+type PGCSeasonStruct struct {
+	SeasonID    int64              `json:"season_id"`
+	SeasonTitle string             `json:"season_title"`
+	Title       string             `json:"title"`
+	Type        int                `json:"type"`
+	Cover       string             `json:"cover"`
+	Evaluate    string             `json:"evaluate"`
+	Episodes    []PGCEpisodeStruct `json:"episodes"`
+	Course      bool               `json:"course,omitempty"` // 课程, 使用课程接口获取播放地址
+}
+
+type PGCEpisodeStruct struct {
+	ID        int64  `json:"id"` // ep_id
+	Aid       int64  `json:"aid"`
+	Bvid      string `json:"bvid"`
+	Cid       int64  `json:"cid"`
+	Title     string `json:"title"` // 集数, 如 "1"
+	LongTitle string `json:"long_title"`
+	PubTime   int    `json:"pub_time"`
+	Duration  int    `json:"duration"` // 毫秒
+	Cover     string `json:"cover"`
+}
+
+// PGC 类型名称
+func (s *PGCSeasonStruct) TypeName() string {
+	if s.Course {
+		return "课程"
+	}
+	switch s.Type {
+	case 1:
+		return "番剧"
+	case 2:
+		return "电影"
+	case 3:
+		return "纪录片"
+	case 4:
+		return "国创"
+	case 5:
+		return "电视剧"
+	case 7:
+		return "综艺"
+	}
+	return "影视"
+}
+
+// 课程信息
+type CourseSeasonStruct struct {
+	SeasonID int64  `json:"season_id"`
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle"`
+	Cover    string `json:"cover"`
+	UpInfo   struct {
+		Mid   int64  `json:"mid"`
+		Uname string `json:"uname"`
+	} `json:"up_info"`
+	Episodes []CourseEpisodeStruct `json:"episodes"`
+}
+
+// 课程单集信息
+type CourseEpisodeStruct struct {
+	ID          int64  `json:"id"` // ep_id
+	Aid         int64  `json:"aid"`
+	Cid         int64  `json:"cid"`
+	Title       string `json:"title"`
+	Index       int    `json:"index"`
+	Duration    int    `json:"duration"` // 秒
+	ReleaseDate int    `json:"release_date"`
+	Cover       string `json:"cover"`
+}
+
+// 转换为剧集信息, 与 PGC 使用相同的留档流程
+func (c *CourseSeasonStruct) PGCSeason() PGCSeasonStruct {
+	season := PGCSeasonStruct{
+		SeasonID: c.SeasonID,
+		Title:    c.Title,
+		Cover:    c.Cover,
+		Evaluate: c.Subtitle,
+		Course:   true,
+	}
+	for _, ep := range c.Episodes {
+		season.Episodes = append(season.Episodes, PGCEpisodeStruct{
+			ID:        ep.ID,
+			Aid:       ep.Aid,
+			Cid:       ep.Cid,
+			Title:     strconv.Itoa(ep.Index),
+			LongTitle: ep.Title,
+			PubTime:   ep.ReleaseDate,
+			Duration:  ep.Duration * 1000,
+			Cover:     ep.Cover,
+		})
+	}
+	return season
+}
+
+// 音频区歌曲信息
+type AudioInfoStruct struct {
+	ID       int64  `json:"id"` // auid
+	UID      int64  `json:"uid"`
+	Uname    string `json:"uname"`
+	Author   string `json:"author"`
+	Title    string `json:"title"`
+	Cover    string `json:"cover"`
+	Intro    string `json:"intro"`
+	Lyric    string `json:"lyric"` // 歌词文件地址
+	Duration int    `json:"duration"`
+	Passtime int    `json:"passtime"`
+	Aid      int64  `json:"aid"`
+	Bvid     string `json:"bvid"`
+	Cid      int64  `json:"cid"`
+}
+
+type AudioURLStruct struct {
+	Sid     int64    `json:"sid"`
+	Type    int      `json:"type"`
+	Timeout int      `json:"timeout"`
+	Size    int64    `json:"size"`
+	Cdns    []string `json:"cdns"`
+}
+
 type PlayInfoStruct struct {
 	From              string   `json:"from"`
 	Result            string   `json:"result"`
@@ -167,68 +418,17 @@ type PlayInfoStruct struct {
 	SeekType          string   `json:"seek_type"`
 	Durl              any      `json:"durl"`
 	Dash              struct {
-		Duration      int     `json:"duration"`
-		MinBufferTime float64 `json:"min_buffer_time"`
-		Video         []struct {
-			ID           int      `json:"id"`
-			BaseURL      string   `json:"base_url"`
-			BackupURL    []string `json:"backup_url"`
-			Bandwidth    int      `json:"bandwidth"`
-			MimeType     string   `json:"mime_type"`
-			Codecs       string   `json:"codecs"`
-			Width        int      `json:"width"`
-			Height       int      `json:"height"`
-			FrameRate    string   `json:"frame_rate"`
-			Sar          string   `json:"sar"`
-			StartWithSap int      `json:"start_with_sap"`
-			SegmentBase  struct {
-				Initialization string `json:"initialization"`
-				IndexRange     string `json:"index_range"`
-			} `json:"segment_base"`
-			Codecid int `json:"codecid"`
-		} `json:"video"`
-		Audio []struct {
-			ID           int      `json:"id"`
-			BaseURL      string   `json:"base_url"`
-			BackupURL    []string `json:"backup_url"`
-			Bandwidth    int      `json:"bandwidth"`
-			MimeType     string   `json:"mime_type"`
-			Codecs       string   `json:"codecs"`
-			Width        int      `json:"width"`
-			Height       int      `json:"height"`
-			FrameRate    string   `json:"frame_rate"`
-			Sar          string   `json:"sar"`
-			StartWithSap int      `json:"start_with_sap"`
-			SegmentBase  struct {
-				Initialization string `json:"initialization"`
-				IndexRange     string `json:"index_range"`
-			} `json:"segment_base"`
-			Codecid int `json:"codecid"`
-		} `json:"audio"`
-		Dolby struct {
-			Type  int `json:"type"`
-			Audio any `json:"audio"`
+		Duration      int          `json:"duration"`
+		MinBufferTime float64      `json:"min_buffer_time"`
+		Video         []DashStream `json:"video"`
+		Audio         []DashStream `json:"audio"`
+		Dolby         struct {
+			Type  int          `json:"type"`
+			Audio []DashStream `json:"audio"`
 		} `json:"dolby"`
 		Flac struct {
-			Display bool `json:"display"`
-			Audio   struct {
-				ID           int    `json:"id"`
-				BaseURL      string `json:"base_url"`
-				BackupURL    any    `json:"backup_url"`
-				Bandwidth    int    `json:"bandwidth"`
-				MimeType     string `json:"mime_type"`
-				Codecs       string `json:"codecs"`
-				Width        int    `json:"width"`
-				Height       int    `json:"height"`
-				FrameRate    string `json:"frame_rate"`
-				Sar          string `json:"sar"`
-				StartWithSap int    `json:"start_with_sap"`
-				SegmentBase  struct {
-					Initialization string `json:"initialization"`
-					IndexRange     string `json:"index_range"`
-				} `json:"segment_base"`
-				Codecid int `json:"codecid"`
-			} `json:"audio"`
+			Display bool        `json:"display"`
+			Audio   *DashStream `json:"audio"`
 		} `json:"flac"`
 	} `json:"dash"`
 	SupportFormats []struct {
@@ -244,6 +444,25 @@ type PlayInfoStruct struct {
 	LastPlayCid  int64 `json:"last_play_cid"`
 }
 
+type DashStream struct {
+	ID           int      `json:"id"`
+	BaseURL      string   `json:"base_url"`
+	BackupURL    []string `json:"backup_url"`
+	Bandwidth    int      `json:"bandwidth"`
+	MimeType     string   `json:"mime_type"`
+	Codecs       string   `json:"codecs"`
+	Width        int      `json:"width"`
+	Height       int      `json:"height"`
+	FrameRate    string   `json:"frame_rate"`
+	Sar          string   `json:"sar"`
+	StartWithSap int      `json:"start_with_sap"`
+	SegmentBase  struct {
+		Initialization string `json:"initialization"`
+		IndexRange     string `json:"index_range"`
+	} `json:"segment_base"`
+	Codecid int `json:"codecid"`
+}
+
 type VideoMetaStruct struct {
 	Aid       int64  `json:"aid"`
 	Videos    int    `json:"videos"`
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 画质名称与 qn 对照
+var qualityNames = map[string]int{
+	"240P":    6,
+	"360P":    16,
+	"480P":    32,
+	"720P":    64,
+	"720P60":  74,
+	"1080P":   80,
+	"1080P+":  112,
+	"1080P60": 116,
+	"4K":      120,
+	"HDR":     125,
+	"DOLBY":   126,
+	"8K":      127,
+}
+
+// 解析画质配置, 支持名称 (如 1080P60, 4K) 或 qn 数字
+func ParseQuality(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	if qn, ok := qualityNames[s]; ok {
+		return qn, nil
+	}
+	qn, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("未知画质: %s", s)
+	}
+	return qn, nil
+}
+
+// 视频流编码名称 avc/hevc/av1
+func (ds *DashStream) CodecName() string {
+	switch {
+	case ds.Codecid == 7 || strings.HasPrefix(ds.Codecs, "avc"):
+		return "avc"
+	case ds.Codecid == 12 || strings.HasPrefix(ds.Codecs, "hev") || strings.HasPrefix(ds.Codecs, "hvc"):
+		return "hevc"
+	case ds.Codecid == 13 || strings.HasPrefix(ds.Codecs, "av01"):
+		return "av1"
+	}
+	return ds.Codecs
+}
+
+// 音视频流选择策略
+type StreamPolicy struct {
+	MaxQuality int      `json:"max_quality"` // 最高画质 qn, 0 表示不限制
+	Codecs     []string `json:"codecs"`      // 编码优先级
+	CodecFirst bool     `json:"codec_first"` // 优先保证编码, 其次画质
+	HiResAudio bool     `json:"hires_audio"` // 优先下载 Hi-Res 无损/杜比全景声音轨
+	AudioOnly  bool     `json:"audio_only"`  // 只下载音频
+}
+
+// 请求播放地址时使用的 fnval
+func (sp StreamPolicy) Fnval() int {
+	fnval := 16 | 64 | 128 | 512 | 1024 | 2048 // DASH, HDR, 4K, 杜比视界, 8K, AV1
+	if sp.HiResAudio {
+		fnval |= 256 // 杜比音效
+	}
+	return fnval
+}
+
+// 编码在优先级中的位置, 未配置的编码排在最后
+func (sp StreamPolicy) codecRank(ds *DashStream) int {
+	name := ds.CodecName()
+	for i, c := range sp.Codecs {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return len(sp.Codecs)
+}
+
+func (sp StreamPolicy) better(a, b *DashStream) bool {
+	ra, rb := sp.codecRank(a), sp.codecRank(b)
+	if sp.CodecFirst {
+		return ra < rb || (ra == rb && a.ID > b.ID)
+	}
+	return a.ID > b.ID || (a.ID == b.ID && ra < rb)
+}
+
+// 选择视频流: 不超过最高画质的流中按策略选择, 全部超过时退回到最低画质
+func (sp StreamPolicy) selectVideo(streams []DashStream) *DashStream {
+	var candidates []*DashStream
+	for i := range streams {
+		if sp.MaxQuality == 0 || streams[i].ID <= sp.MaxQuality {
+			candidates = append(candidates, &streams[i])
+		}
+	}
+	if len(candidates) == 0 {
+		lowest := streams[0].ID
+		for i := range streams {
+			lowest = min(lowest, streams[i].ID)
+		}
+		for i := range streams {
+			if streams[i].ID == lowest {
+				candidates = append(candidates, &streams[i])
+			}
+		}
+	}
+	best := candidates[0]
+	for _, v := range candidates[1:] {
+		if sp.better(v, best) {
+			best = v
+		}
+	}
+	return best
+}
+
+// 选择音频流: 开启 Hi-Res 时依次优先无损、杜比音轨, 否则选择音质最高的普通音轨
+// 没有可用的音轨时返回 nil
+func (sp StreamPolicy) selectAudio(pi *PlayInfoStruct) *DashStream {
+	if sp.HiResAudio {
+		if flac := pi.Dash.Flac.Audio; flac != nil && flac.BaseURL != "" {
+			return flac
+		}
+		if len(pi.Dash.Dolby.Audio) != 0 {
+			return &pi.Dash.Dolby.Audio[0]
+		}
+	}
+	streams := pi.Dash.Audio
+	if len(streams) == 0 {
+		return nil
+	}
+	best := &streams[0]
+	for i := range streams {
+		if streams[i].ID > best.ID {
+			best = &streams[i]
+		}
+	}
+	return best
+}
+
+// 根据音频编码选择封装格式, MP4 无法封装 FLAC 时使用 MKV
+// 只下载音频时使用 m4a 或 flac
+func containerFor(audio *DashStream, audioOnly bool) string {
+	flac := strings.EqualFold(audio.Codecs, "flac")
+	switch {
+	case audioOnly && flac:
+		return "flac"
+	case audioOnly:
+		return "m4a"
+	case flac:
+		return "mkv"
+	}
+	return "mp4"
+}
+
+// 主链接及所有备用链接 (CDN 镜像)
+func streamUrls(ds *DashStream) DownloadUrls {
+	var urls DownloadUrls
+	for _, u := range append([]string{ds.BaseURL}, ds.BackupURL...) {
+		if u != "" && !slices.Contains(urls.Urls, u) {
+			urls.Urls = append(urls.Urls, u)
+		}
+	}
+	return urls
+}
+
+// 实际下载的音视频流信息, 记录在留档状态数据库中
+type StreamInfo struct {
+	Quality     int    `json:"quality"`
+	Description string `json:"description"`
+	VideoCodecs string `json:"video_codecs"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	FrameRate   string `json:"frame_rate"`
+	AudioID     int    `json:"audio_id"`
+	AudioCodecs string `json:"audio_codecs"`
+	Container   string `json:"container"` // 封装格式 mp4/mkv
+	Duration    int    `json:"duration"`  // 时长(秒)
+}
+
+type StreamSelection struct {
+	Info  StreamInfo
+	Video DownloadUrls
+	Audio DownloadUrls
+}
+
+// 从播放信息中按策略选择要下载的音视频流
+func (pi *PlayInfoStruct) SelectStreams(policy StreamPolicy) (StreamSelection, error) {
+	var sel StreamSelection
+	audio := policy.selectAudio(pi)
+	if audio == nil || (len(pi.Dash.Video) == 0 && !policy.AudioOnly) {
+		return sel, fmt.Errorf("投稿播放信息为空")
+	}
+	sel.Audio = streamUrls(audio)
+	sel.Info = StreamInfo{
+		Description: "音频",
+		AudioID:     audio.ID,
+		AudioCodecs: audio.Codecs,
+		Container:   containerFor(audio, policy.AudioOnly),
+		Duration:    pi.DurationSeconds(),
+	}
+	if policy.AudioOnly {
+		return sel, nil
+	}
+	video := policy.selectVideo(pi.Dash.Video)
+	sel.Video = streamUrls(video)
+	sel.Info.Quality = video.ID
+	sel.Info.Description = pi.QualityDescription(video.ID)
+	sel.Info.VideoCodecs = video.Codecs
+	sel.Info.Width = video.Width
+	sel.Info.Height = video.Height
+	sel.Info.FrameRate = video.FrameRate
+	return sel, nil
+}
+
+// 播放时长(秒)
+func (pi *PlayInfoStruct) DurationSeconds() int {
+	if pi.Dash.Duration > 0 {
+		return pi.Dash.Duration
+	}
+	return pi.Timelength / 1000
+}
+
+// 获取画质描述
+func (pi *PlayInfoStruct) QualityDescription(quality int) string {
+	var qualityStr string = "画质未知"
+	for _, d := range pi.SupportFormats {
+		if d.Quality == quality {
+			qualityStr = d.NewDescription
+		}
+	}
+	return qualityStr
+}
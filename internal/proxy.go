@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/proxy"
+)
+
+// API、gRPC 和 CDN 下载分别使用的代理, 支持 http/https/socks5
+// 下载代理可以配置多个, 各分块轮流使用健康的代理
+
+const ProxyDirect = "direct" // 下载代理池中表示直连
+
+type ProxyConfig struct {
+	API                 string   `yaml:"api"`                   // REST API 代理
+	GRPC                string   `yaml:"grpc"`                  // gRPC 代理
+	Download            []string `yaml:"download"`              // CDN 下载代理池
+	HealthCheckURL      string   `yaml:"health_check_url"`      // 下载代理健康检查地址
+	HealthCheckInterval int      `yaml:"health_check_interval"` // 下载代理健康检查间隔(分钟)
+}
+
+// 检查代理配置
+func (pc *ProxyConfig) validate() error {
+	for _, p := range append([]string{pc.API, pc.GRPC}, pc.Download...) {
+		if p == "" || p == ProxyDirect {
+			continue
+		}
+		if _, err := parseProxy(p); err != nil {
+			return err
+		}
+	}
+	if pc.GRPC != "" {
+		if _, err := proxyDialer(pc.GRPC); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("代理地址无效: %s", s)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return u, nil
+	}
+	return nil, fmt.Errorf("不支持的代理类型: %s", s)
+}
+
+// gRPC 使用的代理拨号函数, http 代理使用 CONNECT 建立隧道
+func proxyDialer(proxyAddr string) (func(ctx context.Context, addr string) (net.Conn, error), error) {
+	u, err := parseProxy(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "socks5" || u.Scheme == "socks5h" {
+		d, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			if cd, ok := d.(proxy.ContextDialer); ok {
+				return cd.DialContext(ctx, "tcp", addr)
+			}
+			return d.Dial("tcp", addr)
+		}, nil
+	}
+	if u.Scheme != "http" {
+		return nil, fmt.Errorf("gRPC 不支持该代理类型: %s", proxyAddr)
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialConnect(ctx, u, addr)
+	}, nil
+}
+
+// 通过 http 代理的 CONNECT 方法连接目标地址
+func dialConnect(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", addr, addr)
+	if u.User != nil {
+		pw, _ := u.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + pw))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("代理连接失败: %s", resp.Status)
+	}
+	return conn, nil
+}
+
+// 下载出口, 直连或经过一个代理
+type egress struct {
+	name     string
+	client   *resty.Client
+	healthy  atomic.Bool
+	failures atomic.Int32 // 连续失败次数
+}
+
+// 连续失败超过该次数时暂时停用, 等待健康检查恢复
+const egressMaxFailures = 3
+
+// 下载代理池
+type ProxyPool struct {
+	egresses []*egress
+	next     atomic.Uint64
+}
+
+// 创建下载代理池, 没有配置代理时只有直连
+func NewProxyPool(proxies []string) *ProxyPool {
+	if len(proxies) == 0 {
+		proxies = []string{ProxyDirect}
+	}
+	pool := &ProxyPool{}
+	for _, p := range proxies {
+		e := &egress{name: p, client: resty.New()}
+		if p != ProxyDirect {
+			e.client.SetProxy(p)
+		}
+		e.healthy.Store(true)
+		pool.egresses = append(pool.egresses, e)
+	}
+	return pool
+}
+
+// 轮流选择健康的出口, 全部不可用时仍然轮流使用
+func (pp *ProxyPool) Pick() *egress {
+	n := uint64(len(pp.egresses))
+	start := pp.next.Add(1)
+	for i := range n {
+		if e := pp.egresses[(start+i)%n]; e.healthy.Load() {
+			return e
+		}
+	}
+	return pp.egresses[start%n]
+}
+
+// 记录出口的请求结果
+func (pp *ProxyPool) Report(e *egress, err error) {
+	if err == nil {
+		e.failures.Store(0)
+		return
+	}
+	if e.failures.Add(1) >= egressMaxFailures && len(pp.egresses) > 1 && e.healthy.Swap(false) {
+		log.Warn().Err(err).Msgf("下载代理连续失败, 暂停使用: %s", e.name)
+	}
+}
+
+// 定期检查所有出口, 恢复可用的出口, ctx 取消时退出
+func (pp *ProxyPool) RunHealthCheck(ctx context.Context, checkURL string, interval time.Duration) {
+	for {
+		for _, e := range pp.egresses {
+			err := e.check(ctx, checkURL)
+			if ctx.Err() != nil {
+				return // 程序退出, 不改变出口状态
+			}
+			ok := err == nil
+			if ok {
+				e.failures.Store(0)
+			}
+			if e.healthy.Swap(ok) != ok {
+				if ok {
+					log.Info().Msgf("下载代理已恢复: %s", e.name)
+				} else {
+					log.Warn().Err(err).Msgf("下载代理不可用: %s", e.name)
+				}
+			}
+		}
+		if SleepContext(ctx, interval) != nil {
+			return
+		}
+	}
+}
+
+func (e *egress) check(ctx context.Context, checkURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	resp, err := e.client.R().
+		SetContext(ctx).
+		SetHeader("Referer", "https://www.bilibili.com/").
+		Head(checkURL)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() >= 500 {
+		return fmt.Errorf("响应异常: %d", resp.StatusCode())
+	}
+	return nil
+}
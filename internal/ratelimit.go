@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/rpc"
+)
+
+// 所有账号的 HTTP 和 gRPC 请求共用一个限速器 (由 main 创建后传入各客户端), 并按接口单独限速
+// 触发风控时暂停所有请求, 连续触发时冷却时间翻倍
+
+// 限速配置
+type RateLimitConfig struct {
+	Rate         float64            `yaml:"rate"`          // 每秒请求数
+	Burst        int                `yaml:"burst"`         // 允许的突发请求数
+	Endpoints    map[string]float64 `yaml:"endpoints"`     // 各接口每秒请求数, 接口名称见 endpointNames
+	RiskCooldown int                `yaml:"risk_cooldown"` // 触发风控后的冷却时间(分钟), 连续触发时翻倍
+	MaxCooldown  int                `yaml:"max_cooldown"`  // 最长冷却时间(分钟), 默认 6 小时, 小于冷却时间时按冷却时间处理
+}
+
+// 接口名称, 用于配置单独的限速
+var endpointNames = map[string]string{
+	"/x/v3/fav/resource/list":                          "fav",
+	"/x/v3/fav/folder/created/list-all":                "fav",
+	"/x/v3/fav/folder/collected/list":                  "fav",
+	"/x/space/fav/season/list":                         "fav",
+	"/x/player/playurl":                                "playurl",
+	"/pgc/player/web/playurl":                          "playurl",
+	"/pugv/player/web/playurl":                         "playurl",
+	"/audio/music-service-c/web/url":                   "playurl",
+	"/x/space/wbi/arc/search":                          "space",
+	"/x/space/like/video":                              "space",
+	"/x/space/coin/video":                              "space",
+	"/x/web-interface/card":                            "space",
+	"/bilibili.app.view.v1.View/View":                  "view",
+	"/bilibili.community.service.dm.v1.DM/DmSegMobile": "danmaku",
+}
+
+// B站风控错误码
+var riskCodes = map[int]bool{
+	-352: true, // 风控校验失败
+	-412: true, // 请求被拦截
+	-799: true, // 请求过于频繁
+}
+
+func IsRiskCode(code int) bool {
+	return riskCodes[code]
+}
+
+// gRPC 请求是否触发风控
+func IsGRPCRiskError(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	if st.Code() == codes.ResourceExhausted {
+		return true
+	}
+	for _, d := range st.Details() {
+		if rs, ok := d.(*rpc.Status); ok && IsRiskCode(int(rs.Code)) {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// 等待获取一个令牌
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mutex.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mutex.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mutex.Unlock()
+		if err := SleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+type RateLimiter struct {
+	global    *tokenBucket
+	endpoints map[string]*tokenBucket
+
+	cooldown    time.Duration // 首次触发风控的冷却时间
+	maxCooldown time.Duration
+	level       int           // 连续触发风控的次数
+	lastPause   time.Duration // 上一次的冷却时间
+	pausedUntil time.Time
+	riskMutex   sync.RWMutex
+
+	notification      string // 触发风控时发送通知
+	notificationProxy string
+}
+
+// 按配置创建限速器, 触发风控时按 notification 发送通知
+func (c *Config) NewRateLimiter() *RateLimiter {
+	rl := NewRateLimiter(c.RateLimit)
+	rl.notification = c.Notification
+	rl.notificationProxy = c.NotificationProxy
+	return rl
+}
+
+func NewRateLimiter(c RateLimitConfig) *RateLimiter {
+	rl := &RateLimiter{
+		endpoints:   make(map[string]*tokenBucket),
+		cooldown:    time.Duration(c.RiskCooldown) * time.Minute,
+		maxCooldown: time.Duration(c.MaxCooldown) * time.Minute,
+	}
+	if c.Rate > 0 {
+		rl.global = newTokenBucket(c.Rate, c.Burst)
+	}
+	for name, rate := range c.Endpoints {
+		if rate > 0 {
+			rl.endpoints[name] = newTokenBucket(rate, 1)
+		}
+	}
+	if rl.cooldown <= 0 {
+		rl.cooldown = 5 * time.Minute
+	}
+	if rl.maxCooldown <= 0 {
+		rl.maxCooldown = 6 * time.Hour
+	}
+	if rl.maxCooldown < rl.cooldown {
+		log.Warn().Msgf("max_cooldown(%s) 小于 risk_cooldown(%s), 按 risk_cooldown 处理", rl.maxCooldown, rl.cooldown)
+		rl.maxCooldown = rl.cooldown
+	}
+	return rl
+}
+
+// 获取接口名称, api 为 HTTP 链接或 gRPC 方法
+func endpointName(api string) string {
+	if u, err := url.Parse(api); err == nil && u.Host != "" {
+		api = u.Path
+	}
+	if name, ok := endpointNames[api]; ok {
+		return name
+	}
+	return api
+}
+
+// 请求前等待风控冷却和限速, ctx 取消时返回错误
+func (rl *RateLimiter) Wait(ctx context.Context, api string) error {
+	if err := rl.WaitRisk(ctx); err != nil {
+		return err
+	}
+	if b, ok := rl.endpoints[endpointName(api)]; ok {
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+	}
+	if rl.global != nil {
+		return rl.global.wait(ctx)
+	}
+	return nil
+}
+
+// 等待风控冷却结束
+func (rl *RateLimiter) WaitRisk(ctx context.Context) error {
+	for {
+		rl.riskMutex.RLock()
+		d := time.Until(rl.pausedUntil)
+		rl.riskMutex.RUnlock()
+		if d <= 0 {
+			return nil
+		}
+		if err := SleepContext(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// 触发风控, 暂停所有请求; 冷却结束后不久再次触发时冷却时间翻倍
+func (rl *RateLimiter) TriggerRisk(api string, reason error) {
+	rl.riskMutex.Lock()
+	now := time.Now()
+	if now.Before(rl.pausedUntil) {
+		rl.riskMutex.Unlock()
+		return
+	}
+	if rl.level > 0 && now.Sub(rl.pausedUntil) < rl.lastPause*2 {
+		rl.level++
+	} else {
+		rl.level = 1
+	}
+	pause := rl.cooldown
+	for i := 1; i < rl.level && pause < rl.maxCooldown; i++ {
+		pause *= 2
+	}
+	pause = min(pause, rl.maxCooldown)
+	rl.lastPause = pause
+	rl.pausedUntil = now.Add(pause)
+	level := rl.level
+	rl.riskMutex.Unlock()
+
+	msg := fmt.Sprintf("触发B站风控, 暂停所有请求 %s (连续第 %d 次)\n接口: %s\n%v", pause, level, endpointName(api), reason)
+	log.Warn().Msg(msg)
+	if rl.notification != "" {
+		go SendNotification(rl.notification, msg, rl.notificationProxy)
+	}
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMaxCooldownClampedToCooldown(t *testing.T) {
+	rl := NewRateLimiter(RateLimitConfig{RiskCooldown: 30, MaxCooldown: 10})
+	if rl.maxCooldown != 30*time.Minute {
+		t.Fatalf("最长冷却时间 = %s, 预期 30m", rl.maxCooldown)
+	}
+	if rl := NewRateLimiter(RateLimitConfig{}); rl.maxCooldown != 6*time.Hour {
+		t.Fatalf("默认最长冷却时间 = %s, 预期 6h", rl.maxCooldown)
+	}
+}
+
+func TestRetriesWaitForLimiter(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"code":0,"data":{}}`))
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(RateLimitConfig{Rate: 0.001, Burst: 3})
+	var result map[string]any
+	if err := NewBApiClient(context.Background(), nil, limiter).GET(server.URL+"/x/test", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	// 第一次请求失败后重试, 两次请求各消耗一个令牌
+	if attempts.Load() != 2 {
+		t.Fatalf("请求次数 = %d, 预期 2", attempts.Load())
+	}
+	if tokens := limiter.global.tokens; tokens > 1.01 {
+		t.Fatalf("剩余令牌 = %.2f, 预期重试时也等待限速", tokens)
+	}
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// 规则可以匹配的来源类型
+var ruleKinds = []string{"fav", "season", "upper", "toview", "liked", "coined", "history"}
+
+// 收藏夹规则, 按顺序匹配, 使用第一条匹配的规则
+// 匹配条件为空的规则匹配所有收藏夹, 可以放在最后作为默认规则
+type FolderRule struct {
+	Kind    []string `yaml:"kind"`    // 来源类型, 为空时匹配所有来源
+	ID      []int64  `yaml:"id"`      // 来源 ID, 按 kind 区分收藏夹/合集 ID 或 UP主 mid, 未设置 kind 时只匹配收藏夹
+	Name    []string `yaml:"name"`    // 名称完全匹配
+	Regex   string   `yaml:"regex"`   // 名称正则匹配
+	Exclude bool     `yaml:"exclude"` // 匹配的收藏夹不同步
+
+	// 以下设置为空时使用全局配置
+	PathTemplate   *string      `yaml:"path_template"`
+	MaxQuality     *string      `yaml:"max_quality"`
+	Codecs         []string     `yaml:"codecs"`
+	CodecFirst     *bool        `yaml:"codec_first"`
+	HiResAudio     *bool        `yaml:"hires_audio"`
+	AudioOnly      *bool        `yaml:"audio_only"`
+	Danmaku        *bool        `yaml:"danmaku"`
+	UpdateDL       *int         `yaml:"update_dl"`
+	CustomScript   *string      `yaml:"custom_script"`
+	RunAfterUpdate *string      `yaml:"run_after_update"`
+	Filter         *VideoFilter `yaml:"filter"` // 替换全局的投稿过滤条件
+
+	regex        *regexp.Regexp
+	maxQualityQn int
+}
+
+// 收藏夹生效的设置
+type FolderSettings struct {
+	PathTemplate   string
+	Policy         StreamPolicy
+	Danmaku        bool
+	UpdateDL       int
+	CustomScript   string
+	RunAfterUpdate string
+	Filter         VideoFilter
+}
+
+// 检查规则并预先解析正则和画质
+func (r *FolderRule) compile() error {
+	for _, k := range r.Kind {
+		if !slices.Contains(ruleKinds, k) {
+			return fmt.Errorf("规则来源类型无效: %s, 可选 %v", k, ruleKinds)
+		}
+	}
+	if r.Regex != "" {
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return fmt.Errorf("规则正则表达式无效: %s: %v", r.Regex, err)
+		}
+		r.regex = re
+	}
+	if r.MaxQuality != nil {
+		qn, err := ParseQuality(*r.MaxQuality)
+		if err != nil {
+			return err
+		}
+		r.maxQualityQn = qn
+	}
+	if r.Filter != nil {
+		return r.Filter.compile()
+	}
+	return nil
+}
+
+// 判断规则是否匹配来源, 来源类型符合时满足任一条件即匹配
+// 不同类型的 ID 可能重复, 未设置 kind 时 id 只匹配收藏夹
+func (r *FolderRule) Match(kind string, id int64, name string) bool {
+	if len(r.Kind) != 0 && !slices.Contains(r.Kind, kind) {
+		return false
+	}
+	if len(r.ID) == 0 && len(r.Name) == 0 && r.regex == nil {
+		return true
+	}
+	idMatched := slices.Contains(r.ID, id) && (len(r.Kind) != 0 || kind == "fav")
+	return idMatched ||
+		slices.Contains(r.Name, name) ||
+		(r.regex != nil && r.regex.MatchString(name))
+}
+
+// 获取第一条匹配的规则, 没有时返回 nil
+func (c *Config) MatchRule(kind string, id int64, name string) *FolderRule {
+	for i := range c.Rules {
+		if c.Rules[i].Match(kind, id, name) {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// 判断来源是否需要同步: 有匹配的规则时由规则决定, 否则收藏夹和合集按关键词过滤
+// UP主、稍后再看、点赞、投币和历史记录已由各自的开关启用, 不按关键词过滤
+func (c *Config) FolderEnabled(kind string, id int64, name string) bool {
+	if rule := c.MatchRule(kind, id, name); rule != nil {
+		return !rule.Exclude
+	}
+	if kind != "fav" && kind != "season" {
+		return true
+	}
+	return len(c.Keywords) == 0 || containsAny(name, c.Keywords)
+}
+
+// 解析收藏夹生效的设置, template 为来源默认的路径模板, 为空时使用 path_template
+func (c *Config) FolderSettings(kind string, id int64, name, template string) FolderSettings {
+	if template == "" {
+		template = c.PathTemplate
+	}
+	fs := FolderSettings{
+		PathTemplate:   template,
+		Policy:         c.StreamPolicy(),
+		Danmaku:        c.Danmaku,
+		UpdateDL:       c.UpdateDL,
+		CustomScript:   c.CustomScript,
+		RunAfterUpdate: c.RunAfterUpdate,
+		Filter:         c.Filter,
+	}
+	fs.Policy.AudioOnly = containsAny(name, c.AudioOnlyFolders)
+
+	rule := c.MatchRule(kind, id, name)
+	if rule == nil {
+		return fs
+	}
+	if rule.PathTemplate != nil {
+		fs.PathTemplate = *rule.PathTemplate
+	}
+	if rule.MaxQuality != nil {
+		fs.Policy.MaxQuality = rule.maxQualityQn
+	}
+	if len(rule.Codecs) != 0 {
+		fs.Policy.Codecs = rule.Codecs
+	}
+	if rule.CodecFirst != nil {
+		fs.Policy.CodecFirst = *rule.CodecFirst
+	}
+	if rule.HiResAudio != nil {
+		fs.Policy.HiResAudio = *rule.HiResAudio
+	}
+	if rule.AudioOnly != nil {
+		fs.Policy.AudioOnly = *rule.AudioOnly
+	}
+	if rule.Danmaku != nil {
+		fs.Danmaku = *rule.Danmaku
+	}
+	if rule.UpdateDL != nil {
+		fs.UpdateDL = *rule.UpdateDL
+	}
+	if rule.CustomScript != nil {
+		fs.CustomScript = *rule.CustomScript
+	}
+	if rule.RunAfterUpdate != nil {
+		fs.RunAfterUpdate = *rule.RunAfterUpdate
+	}
+	if rule.Filter != nil {
+		fs.Filter = *rule.Filter
+	}
+	return fs
+}
+
+// 名称包含任一关键词
+func containsAny(name string, keywords []string) bool {
+	for _, k := range keywords {
+		if strings.Contains(name, k) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,523 @@
+package internal
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 留档状态数据库, 记录已留档投稿及其分P的保存路径和下载状态
+// 避免每次更新元数据都遍历整个存储目录
+
+const (
+	PageStatusPending = "pending" // 等待下载
+	PageStatusDone    = "done"    // 下载完成
+	PageStatusFailed  = "failed"  // 下载失败
+
+	TaskStatusQueued = "queued" // 等待下载或等待重试
+	TaskStatusFailed = "failed" // 超过重试次数, 不再重试
+)
+
+var (
+	bucketVideos      = []byte("videos")
+	bucketCheckpoints = []byte("checkpoints")
+	bucketTasks       = []byte("tasks")
+	bucketContents    = []byte("contents")
+	bucketFailures    = []byte("failures")
+	bucketGroups      = []byte("groups")
+)
+
+type PageRecord struct {
+	Cid       int64      `json:"cid"`
+	Page      int        `json:"page"`
+	Path      string     `json:"path"` // 保存路径(不含扩展名)
+	Status    string     `json:"status"`
+	File      string     `json:"file"`   // 最终文件路径
+	Size      int64      `json:"size"`   // 最终文件大小
+	Stream    StreamInfo `json:"stream"` // 下载的音视频流
+	UpdatedAt int        `json:"updated_at"`
+
+	Links   []string      `json:"links,omitempty"`   // 其他来源中链接到同一内容的文件
+	Waiting []PendingLink `json:"waiting,omitempty"` // 下载完成后需要创建链接的其他来源
+}
+
+// 等待同一内容下载完成后创建的链接
+type PendingLink struct {
+	Path    string `json:"path"`     // 保存路径(不含扩展名)
+	GroupID string `json:"group_id"` // 所属任务组
+}
+
+// 已下载的内容, 按 BV+cid+画质 索引
+type ContentRecord struct {
+	File      string `json:"file"`
+	Size      int64  `json:"size"`
+	UpdatedAt int    `json:"updated_at"`
+}
+
+type VideoRecord struct {
+	Aid           int64                 `json:"aid"`
+	Bvid          string                `json:"bvid"`
+	Title         string                `json:"title"`
+	Source        string                `json:"source"`            // 投稿来源, 与增量同步断点的 key 相同
+	Mid           int64                 `json:"mid"`               // 留档账号 mid, 旧记录为 0
+	Sources       []string              `json:"sources,omitempty"` // 投稿所属的所有来源
+	FavID         int                   `json:"fav_id"`
+	FavName       string                `json:"fav_name"` // 来源名称
+	FavTime       int                   `json:"fav_time"`
+	PageCount     int                   `json:"page_count"`           // 分P数量
+	Ctime         int                   `json:"ctime"`                // 投稿创建时间, 用于判断是否需要更新元数据
+	MetaPath      string                `json:"meta_path"`            // 第一个来源的 _meta.json 路径
+	MetaPaths     map[string]string     `json:"meta_paths,omitempty"` // 按来源 key 记录各来源目录下的 _meta.json 路径
+	Pages         []PageRecord          `json:"pages"`
+	Deleted       bool                  `json:"deleted"`           // 稿件已失效
+	Skipped       map[string]SkipRecord `json:"skipped,omitempty"` // 按来源记录被过滤条件跳过的投稿
+	MetaUpdatedAt int                   `json:"meta_updated_at"`   // 最后一次更新元数据时间
+	CreatedAt     int                   `json:"created_at"`
+}
+
+// 被过滤条件跳过的投稿
+type SkipRecord struct {
+	Reason    string `json:"reason"`
+	Filter    string `json:"filter"` // 跳过时的过滤条件, 条件改变后重新检查
+	SkippedAt int    `json:"skipped_at"`
+}
+
+// 分P是否已留档: 下载完成且最终文件存在, 大小与记录一致
+func (pr *PageRecord) Archived() bool {
+	if pr.Status != PageStatusDone || pr.File == "" {
+		return false
+	}
+	fi, err := os.Stat(pr.File)
+	return err == nil && fi.Size() == pr.Size
+}
+
+// 获取保存路径对应的链接文件
+func (pr *PageRecord) LinkAt(path string) (string, bool) {
+	for _, file := range pr.Links {
+		if strings.TrimSuffix(file, filepath.Ext(file)) == path {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+// 保存路径是否在等待创建链接
+func (pr *PageRecord) IsWaiting(path string) bool {
+	for _, w := range pr.Waiting {
+		if w.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// 记录链接文件
+func (pr *PageRecord) AddLink(file string) {
+	if !slices.Contains(pr.Links, file) {
+		pr.Links = append(pr.Links, file)
+	}
+}
+
+// 内容文件是否存在且大小与记录一致
+func (cr ContentRecord) Exists() bool {
+	fi, err := os.Stat(cr.File)
+	return err == nil && fi.Size() == cr.Size
+}
+
+// 记录来源目录下的 _meta.json 路径
+func (vr *VideoRecord) SetMetaPath(key, path string) {
+	if vr.MetaPaths == nil {
+		vr.MetaPaths = make(map[string]string)
+	}
+	vr.MetaPaths[key] = path
+}
+
+// 所有来源的 _meta.json 路径, 包含只记录在 MetaPath 中的旧路径
+func (vr *VideoRecord) AllMetaPaths() []string {
+	var paths []string
+	if vr.MetaPath != "" {
+		paths = append(paths, vr.MetaPath)
+	}
+	for _, key := range slices.Sorted(maps.Keys(vr.MetaPaths)) {
+		if path := vr.MetaPaths[key]; !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// 投稿是否属于该来源, 没有来源信息的旧记录视为属于所有来源
+func (vr *VideoRecord) HasSource(key string) bool {
+	if vr.Source == "" && len(vr.Sources) == 0 {
+		return true
+	}
+	return vr.Source == key || slices.Contains(vr.Sources, key)
+}
+
+// 记录投稿所属的来源
+func (vr *VideoRecord) AddSource(key string) {
+	if !slices.Contains(vr.Sources, key) {
+		vr.Sources = append(vr.Sources, key)
+	}
+}
+
+// 获取分P记录, 不存在时返回 nil
+func (vr *VideoRecord) GetPage(cid int64) *PageRecord {
+	for i := range vr.Pages {
+		if vr.Pages[i].Cid == cid {
+			return &vr.Pages[i]
+		}
+	}
+	return nil
+}
+
+// 更新或添加分P记录
+func (vr *VideoRecord) SetPage(page PageRecord) {
+	page.UpdatedAt = int(time.Now().Unix())
+	if p := vr.GetPage(page.Cid); p != nil {
+		// 链接文件属于其他来源, 保留
+		if page.Links == nil {
+			page.Links = p.Links
+		}
+		if page.Waiting == nil {
+			page.Waiting = p.Waiting
+		}
+		*p = page
+		return
+	}
+	vr.Pages = append(vr.Pages, page)
+}
+
+// 收藏夹/UP主等来源的增量同步断点, 记录上一次处理到的最新投稿
+type Checkpoint struct {
+	FavTime   int     `json:"fav_time"`
+	MediaID   int64   `json:"media_id"`
+	Anchors   []int64 `json:"anchors,omitempty"` // 没有加入时间的来源中断点及之后的投稿, 断点投稿从列表消失时作为备用
+	UpdatedAt int     `json:"updated_at"`
+}
+
+// 判断投稿是否已在断点之前处理过
+func (cp Checkpoint) Reached(favTime int, mediaID int64) bool {
+	return favTime < cp.FavTime || (favTime == cp.FavTime && mediaID == cp.MediaID)
+}
+
+// 来源中处理失败的投稿, 超过重试次数后不再处理
+type FailureRecord struct {
+	Count     int    `json:"count"`
+	LastError string `json:"last_error"`
+	FailedAt  int    `json:"failed_at"`
+}
+
+// 持久化的任务组进度
+type TaskGroupState struct {
+	Info    TaskGroupInfo   `json:"info"`
+	Pending []int64         `json:"pending"` // 尚未有结果的分P cid
+	Result  TaskGroupResult `json:"result"`
+}
+
+// 持久化的下载任务
+type QueuedTask struct {
+	Task      DownloadTask `json:"task"`
+	Status    string       `json:"status"`
+	Attempts  int          `json:"attempts"`   // 已失败次数
+	NextRetry int          `json:"next_retry"` // 下次下载时间
+	LastError string       `json:"last_error"`
+	CreatedAt int          `json:"created_at"`
+}
+
+type ArchiveStore struct {
+	db *bolt.DB
+}
+
+func OpenArchiveStore(path string) (*ArchiveStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketVideos, bucketCheckpoints, bucketTasks, bucketContents, bucketFailures, bucketGroups} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &ArchiveStore{db: db}, nil
+}
+
+func (s *ArchiveStore) Close() error {
+	return s.db.Close()
+}
+
+func videoKey(aid int64) []byte {
+	return []byte(strconv.FormatInt(aid, 10))
+}
+
+// 获取投稿记录
+func (s *ArchiveStore) GetVideo(aid int64) (VideoRecord, bool) {
+	var rec VideoRecord
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketVideos).Get(videoKey(aid))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &rec) == nil
+		return nil
+	})
+	return rec, found
+}
+
+// 在同一事务中读取并修改投稿记录, 记录不存在时以空记录调用 fn
+func (s *ArchiveStore) UpdateVideo(aid int64, fn func(rec *VideoRecord)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketVideos)
+		var rec VideoRecord
+		if data := b.Get(videoKey(aid)); data != nil {
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+		}
+		if rec.Aid == 0 {
+			rec.Aid = aid
+			rec.CreatedAt = int(time.Now().Unix())
+		}
+		fn(&rec)
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		return b.Put(videoKey(aid), data)
+	})
+}
+
+// 更新分P下载状态
+func (s *ArchiveStore) SetPageStatus(aid, cid int64, status, file string, size int64) error {
+	return s.UpdateVideo(aid, func(rec *VideoRecord) {
+		page := PageRecord{Cid: cid}
+		if p := rec.GetPage(cid); p != nil {
+			page = *p
+		}
+		page.Status = status
+		page.File = file
+		page.Size = size
+		rec.SetPage(page)
+	})
+}
+
+// 获取所有投稿记录
+func (s *ArchiveStore) ListVideos() ([]VideoRecord, error) {
+	var recs []VideoRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketVideos).ForEach(func(k, v []byte) error {
+			var rec VideoRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			recs = append(recs, rec)
+			return nil
+		})
+	})
+	return recs, err
+}
+
+// 投稿记录数量
+func (s *ArchiveStore) CountVideos() int {
+	var n int
+	s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucketVideos).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// 获取收藏夹断点
+func (s *ArchiveStore) GetCheckpoint(key string) (Checkpoint, bool) {
+	var cp Checkpoint
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketCheckpoints).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &cp) == nil
+		return nil
+	})
+	return cp, found
+}
+
+// 保存收藏夹断点
+func (s *ArchiveStore) SaveCheckpoint(key string, cp Checkpoint) error {
+	cp.UpdatedAt = int(time.Now().Unix())
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCheckpoints).Put([]byte(key), data)
+	})
+}
+
+// 获取任务组进度
+func (s *ArchiveStore) GetTaskGroup(id string) (TaskGroupState, bool) {
+	var st TaskGroupState
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketGroups).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &st) == nil
+		return nil
+	})
+	return st, found
+}
+
+// 保存任务组进度
+func (s *ArchiveStore) SaveTaskGroup(id string, st TaskGroupState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGroups).Put([]byte(id), data)
+	})
+}
+
+// 删除已完成的任务组
+func (s *ArchiveStore) DeleteTaskGroup(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketGroups).Delete([]byte(id))
+	})
+}
+
+// 获取投稿在来源中的失败记录, key 为 <来源key>:<投稿ID>
+func (s *ArchiveStore) GetFailure(key string) (FailureRecord, bool) {
+	var fr FailureRecord
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketFailures).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &fr) == nil
+		return nil
+	})
+	return fr, found
+}
+
+// 失败次数加一并记录错误, 返回更新后的记录
+func (s *ArchiveStore) AddFailure(key string, cause error) (FailureRecord, error) {
+	var fr FailureRecord
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketFailures)
+		if data := b.Get([]byte(key)); data != nil {
+			json.Unmarshal(data, &fr)
+		}
+		fr.Count++
+		fr.LastError = cause.Error()
+		fr.FailedAt = int(time.Now().Unix())
+		data, err := json.Marshal(fr)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+	return fr, err
+}
+
+// 删除失败记录, 投稿处理成功后调用
+func (s *ArchiveStore) DeleteFailure(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketFailures).Delete([]byte(key))
+	})
+}
+
+// 获取已下载的内容
+func (s *ArchiveStore) GetContent(key string) (ContentRecord, bool) {
+	var cr ContentRecord
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketContents).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &cr) == nil
+		return nil
+	})
+	return cr, found
+}
+
+// 保存已下载的内容
+func (s *ArchiveStore) SaveContent(key string, cr ContentRecord) error {
+	cr.UpdatedAt = int(time.Now().Unix())
+	data, err := json.Marshal(cr)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketContents).Put([]byte(key), data)
+	})
+}
+
+// 获取下载任务
+func (s *ArchiveStore) GetTask(key string) (QueuedTask, bool) {
+	var qt QueuedTask
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketTasks).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &qt) == nil
+		return nil
+	})
+	return qt, found
+}
+
+// 保存下载任务
+func (s *ArchiveStore) SaveTask(key string, qt QueuedTask) error {
+	data, err := json.Marshal(qt)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTasks).Put([]byte(key), data)
+	})
+}
+
+// 删除下载任务
+func (s *ArchiveStore) DeleteTask(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTasks).Delete([]byte(key))
+	})
+}
+
+// 获取所有下载任务, 按添加时间排序
+func (s *ArchiveStore) ListTasks() ([]QueuedTask, error) {
+	var tasks []QueuedTask
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTasks).ForEach(func(k, v []byte) error {
+			var qt QueuedTask
+			if err := json.Unmarshal(v, &qt); err != nil {
+				return err
+			}
+			tasks = append(tasks, qt)
+			return nil
+		})
+	})
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].CreatedAt < tasks[j].CreatedAt })
+	return tasks, err
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -102,6 +103,19 @@ func FormatDate(t int) string {
 	return time.Unix(int64(t), 0).Format("2006-01-02")
 }
 
+// 解析 "分:秒" 或 "时:分:秒" 格式的时长, 返回秒数, 无法解析时返回 0
+func ParseLength(s string) int {
+	var seconds int
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds
+}
+
 func DM2XmlD(d []*DanmakuStruct) []XmlD {
 	var xd XmlD
 	var xds []XmlD
@@ -129,11 +143,23 @@ func MergeDMList(odms, ldms []XmlD) []XmlD {
 	return xds
 }
 
+// SleepContext 等待一段时间, ctx 取消时提前返回 ctx.Err()
+func SleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
 // ExecCommand 执行命令行，接受输入字符串作为标准输入，并实时输出结果
+// ctx: 上下文，可用于取消命令执行
 // command: 要执行的命令和参数，如 "python main.py"
 // stdin: 要传递给命令的标准输入
-// ctx: 上下文，可用于取消命令执行
-func ExecCommand(command string, stdin string) {
+func ExecCommand(ctx context.Context, command string, stdin string) {
 	// 分割命令和参数
 	cmdFields := strings.Fields(command)
 	if len(cmdFields) == 0 {
@@ -142,7 +168,7 @@ func ExecCommand(command string, stdin string) {
 	}
 	log.Debug().Msgf("执行自定义命令: %s , 输入: %s", command, stdin)
 	// 创建命令
-	cmd := exec.CommandContext(context.Background(), cmdFields[0], cmdFields[1:]...)
+	cmd := exec.CommandContext(ctx, cmdFields[0], cmdFields[1:]...)
 
 	// 设置标准输入
 	stdinPipe, err := cmd.StdinPipe()
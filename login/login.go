@@ -1,6 +1,7 @@
 package login
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -8,8 +9,9 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func Run() {
-	qr, err := internal.BApi.GetQRCode()
+// 扫码登录并保存 cookie 文件, ctx 取消时停止等待扫码
+func Run(ctx context.Context, bapi internal.LoginAPI) {
+	qr, err := bapi.GetQRCode()
 	if err != nil {
 		log.Error().Msg("获取二维码失败")
 		return
@@ -22,8 +24,11 @@ func Run() {
 	}
 	var cookieInfo internal.CookieInfoStruct
 	for {
-		time.Sleep(3 * time.Second)
-		cookieInfo, err = internal.BApi.VerifyQrCode(qr)
+		if internal.SleepContext(ctx, 3*time.Second) != nil {
+			log.Warn().Msg("已取消登录")
+			return
+		}
+		cookieInfo, err = bapi.VerifyQrCode(qr)
 		if err != nil {
 			switch err := err.(type) {
 			case *internal.BiliErr:
@@ -46,8 +51,8 @@ func Run() {
 	}
 	cfName := fmt.Sprintf("%d_cookie.json", cookieInfo.Mid)
 	cookieInfo.SaveToFile(cfName)
-	internal.RefreshToken(cfName)
-	if uf, err := internal.CheckCookieFile(cfName); err {
+	internal.RefreshToken(bapi, cfName)
+	if uf, err := internal.CheckCookieFile(bapi, cfName); err {
 		log.Info().Msgf("%s UID: %v 登录成功 cookie 文件保存在 %s", uf.Uname, uf.Mid, cfName)
 	}
 }
@@ -1,6 +1,7 @@
 package login
 
 import (
+	"context"
 	"os"
 	"os/exec"
 
@@ -9,21 +10,15 @@ import (
 	"github.com/XiaoMiku01/bilibili-archiver/internal"
 )
 
-func RunTest(config internal.Config) {
-	internal.BApi.SetCookieFile(config.User)
-
-	buser, err := internal.BApi.GetUserInfo()
-	if err != nil {
-		log.Fatal().Err(err).Msg("获取用户信息失败")
+func RunTest(ctx context.Context, config internal.Config) {
+	limiter := config.NewRateLimiter()
+	for _, ac := range config.AccountConfigs() {
+		testAccount(internal.NewBApiClient(ctx, &config, limiter), ac.User)
 	}
-	log.Info().Msgf("用户: %s [UID: %d] 登录成功", buser.Uname, buser.Mid)
-	tinfo, _ := internal.BApi.CheckToken()
-	exTime := tinfo.ExpiresIn / 86400
-	log.Info().Msgf("Cookie 有效期: %d 天", exTime)
 	CheckFFmpeg()
 	// 测试通知
 	if config.Notification != "" {
-		err = internal.SendNotification(config.Notification, "B站留档助手 测试通知", config.NotificationProxy)
+		err := internal.SendNotification(config.Notification, "B站留档助手 测试通知", config.NotificationProxy)
 		if err != nil {
 			log.Error().Err(err).Msg("发送通知失败")
 		} else {
@@ -32,11 +27,27 @@ func RunTest(config internal.Config) {
 	}
 }
 
+// 测试账号登录状态和 cookie 有效期
+func testAccount(bapi *internal.BApiClient, cookieFile string) {
+	if err := bapi.SetCookieFile(cookieFile); err != nil {
+		log.Fatal().Err(err).Msgf("读取 cookie 文件失败: %s", cookieFile)
+	}
+	buser, err := bapi.GetUserInfo()
+	if err != nil {
+		log.Fatal().Err(err).Msg("获取用户信息失败")
+	}
+	log.Info().Msgf("用户: %s [UID: %d] 登录成功", buser.Uname, buser.Mid)
+	tinfo, _ := bapi.CheckToken()
+	exTime := tinfo.ExpiresIn / 86400
+	log.Info().Msgf("Cookie 有效期: %d 天", exTime)
+}
+
 func CheckFFmpeg() {
 	// 首先在 PATH 环境变量中查找 ffmpeg
 	ffmpegPath, err := exec.LookPath("ffmpeg")
 	if err == nil {
 		log.Info().Msgf("发现 ffmpeg: %s", ffmpegPath)
+		checkFFprobe()
 		return
 	}
 
@@ -50,3 +61,13 @@ func CheckFFmpeg() {
 	// 都没找到，输出错误
 	log.Fatal().Msg("环境检查失败: 未找到 ffmpeg，请确保已安装 ffmpeg 并添加到环境变量")
 }
+
+// ffprobe 用于校验下载完成的文件, 缺少时只给出警告
+func checkFFprobe() {
+	ffprobePath, err := exec.LookPath("ffprobe")
+	if err == nil {
+		log.Info().Msgf("发现 ffprobe: %s", ffprobePath)
+		return
+	}
+	log.Warn().Msg("未找到 ffprobe，将跳过下载完成后的完整性校验")
+}
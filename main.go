@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/rs/zerolog/log"
@@ -9,6 +14,7 @@ import (
 	"github.com/XiaoMiku01/bilibili-archiver/archiver"
 	"github.com/XiaoMiku01/bilibili-archiver/internal"
 	"github.com/XiaoMiku01/bilibili-archiver/login"
+	"github.com/XiaoMiku01/bilibili-archiver/mock"
 )
 
 var (
@@ -31,6 +37,16 @@ var (
 	// start 命令
 	startCmd = app.Command("start", "开始运行程序")
 
+	// mock 命令
+	mockCmd     = app.Command("mock", "启动模拟B站服务器, 用于离线测试")
+	mockAddr    = mockCmd.Flag("addr", "REST 接口和 CDN 监听地址").Default("127.0.0.1:8080").String()
+	mockGRPC    = mockCmd.Flag("grpc-addr", "gRPC 监听地址").Default("127.0.0.1:9090").String()
+	mockCookie  = mockCmd.Flag("cookie", "写入模拟账号的 cookie 文件").Default("mock_cookie.json").String()
+	mockFolders = mockCmd.Flag("folders", "收藏夹数量").Default("2").Int()
+	mockVideos  = mockCmd.Flag("videos", "每个收藏夹的投稿数量").Default("3").Int()
+	mockPages   = mockCmd.Flag("pages", "每个投稿的分P数量").Default("1").Int()
+	mockSize    = mockCmd.Flag("stream-size", "每个音视频流的大小(字节)").Default("1048576").Int64()
+
 	// test 命令
 )
 
@@ -45,29 +61,91 @@ func main() {
 	log.Info().Str("Version:", Version).Msg("B站留档助手")
 	log.Info().Str("Config:", *config).Msg("配置文件")
 
+	// 收到 SIGINT/SIGTERM 时取消, 各组件保存进度后退出, 再次收到信号时直接退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Warn().Msg("正在退出, 等待下载任务保存进度...")
+	}()
+
 	switch command {
 	case loginCmd.FullCommand():
 		log.Info().Msg("开始登录")
-		login.Run()
+		cfg := loadClientConfig()
+		login.Run(ctx, internal.NewBApiClient(ctx, cfg, cfg.NewRateLimiter()))
 
 	case refreshCmd.FullCommand():
 		log.Info().Msg("开始刷新 Cookie")
-		internal.RefreshToken(*cookieFile)
+		cfg := loadClientConfig()
+		internal.RefreshToken(internal.NewBApiClient(ctx, cfg, cfg.NewRateLimiter()), *cookieFile)
 
 	case startCmd.FullCommand():
 		config, err := internal.LoadConfig(*config)
 		if err != nil {
 			log.Fatal().Err(err).Msg("加载配置文件失败")
 		}
-		internal.DM = internal.NewDownloaderManager()
+		store, err := internal.OpenArchiveStore(config.DBPath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("打开留档状态数据库失败")
+		}
+		defer store.Close()
+		// 所有账号和下载管理器共用限速器, 触发风控时一起暂停
+		limiter := config.NewRateLimiter()
+		dm := internal.NewDownloaderManager(ctx, config, limiter, store)
 		log.Info().Msg("开始运行")
-		archiver := archiver.NewArchiverUser(*config)
-		err = archiver.Init()
+		// 每个账号独立运行, 共用下载管理器
+		var users []*archiver.ArchiverUser
+		for i, ac := range config.AccountConfigs() {
+			au := archiver.NewArchiverUser(ac, internal.NewBApiClient(ctx, config, limiter), dm, store, i == 0)
+			if err := au.Init(); err != nil {
+				log.Fatal().Err(err).Msgf("初始化用户失败: %s", ac.User)
+			}
+			users = append(users, au)
+		}
+		dmDone := make(chan struct{})
+		go func() {
+			dm.Run() // 启动下载管理器
+			close(dmDone)
+		}()
+		var wg sync.WaitGroup
+		for _, au := range users {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				au.Run(ctx)
+			}()
+		}
+		// 等待所有账号和下载任务退出后关闭数据库
+		wg.Wait()
+		<-dmDone
+		log.Info().Msg("已安全退出")
+
+	case mockCmd.FullCommand():
+		server, err := mock.NewServer(mock.Options{
+			Addr:       *mockAddr,
+			GRPCAddr:   *mockGRPC,
+			Folders:    *mockFolders,
+			Videos:     *mockVideos,
+			Pages:      *mockPages,
+			StreamSize: *mockSize,
+		})
 		if err != nil {
-			log.Fatal().Err(err).Msg("初始化用户失败")
+			log.Fatal().Err(err).Msg("启动模拟服务器失败")
+		}
+		if err := server.WriteCookieFile(*mockCookie); err != nil {
+			log.Fatal().Err(err).Msg("写入 cookie 文件失败")
+		}
+		go func() {
+			<-ctx.Done()
+			server.Close()
+		}()
+		ep := server.Endpoints()
+		log.Info().Msgf("模拟账号 cookie 文件: %s, 在配置文件中设置:\nendpoints:\n  api: %s\n  passport: %s\n  www: %s\n  grpc: %s", *mockCookie, ep.API, ep.Passport, ep.WWW, ep.GRPC)
+		if err := server.Serve(); err != nil {
+			log.Fatal().Err(err).Msg("模拟服务器异常退出")
 		}
-		// TODO: 实现启动逻辑
-		archiver.Run()
 
 	case testCmd.FullCommand():
 		log.Info().Msg("测试配置")
@@ -75,7 +153,20 @@ func main() {
 		if err != nil {
 			log.Fatal().Err(err).Msg("加载配置文件失败")
 		}
-		login.RunTest(*config)
+		login.RunTest(ctx, *config)
 		// TODO: 实现测试逻辑
 	}
 }
+
+// 登录和刷新 Cookie 前加载配置, 使接口地址、代理和限速生效; 没有配置文件时使用默认设置
+func loadClientConfig() *internal.Config {
+	if _, err := os.Stat(*config); errors.Is(err, os.ErrNotExist) {
+		log.Warn().Str("Config:", *config).Msg("配置文件不存在, 使用默认接口地址")
+		return &internal.Config{}
+	}
+	cfg, err := internal.LoadConfig(*config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("加载配置文件失败")
+	}
+	return cfg
+}
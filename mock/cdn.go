@@ -0,0 +1,32 @@
+package mock
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CDN, 音视频流和封面支持 HEAD 和 Range 请求
+
+const coverSize = 4096 // 封面大小(字节)
+
+func (s *Server) registerCDN(mux *http.ServeMux) {
+	mux.HandleFunc("/upgcxcode/", s.handleStream)
+	mux.HandleFunc("/mirror/", s.handleStream)
+	mux.HandleFunc("/cover/", s.handleStream)
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Referer") == "" && !strings.HasPrefix(r.URL.Path, "/cover/") {
+		http.Error(w, "Forbidden", http.StatusForbidden) // 与B站 CDN 一致, 缺少 Referer 时拒绝
+		return
+	}
+	// 镜像路径与主路径内容相同
+	path := strings.TrimPrefix(r.URL.Path, "/mirror")
+	data := s.data.stream(path)
+	if strings.HasPrefix(path, "/cover/") {
+		data = data[:min(len(data), coverSize)]
+	}
+	http.ServeContent(w, r, path, time.Time{}, bytes.NewReader(data))
+}
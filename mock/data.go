@@ -0,0 +1,112 @@
+package mock
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 模拟账号的收藏夹和投稿, 按配置生成固定数据
+
+type mockPage struct {
+	Cid      int64
+	Part     string
+	Duration int64 // 秒
+}
+
+type mockVideo struct {
+	Aid       int64
+	Bvid      string
+	Title     string
+	UpperMid  int64
+	UpperName string
+	TypeID    int32
+	Pubdate   int64
+	Pages     []mockPage
+}
+
+type mockFolder struct {
+	ID     int
+	Title  string
+	Videos []*mockVideo
+	FavAt  []int64 // 各投稿的收藏时间
+}
+
+type dataset struct {
+	folders []*mockFolder
+	videos  map[int64]*mockVideo // aid
+	bvids   map[string]*mockVideo
+	pages   map[int64]*mockVideo // cid
+
+	baseURL    string // REST 接口和 CDN 地址
+	streamSize int64
+	streams    sync.Map // 已生成的音视频流数据, key 为 CDN 路径
+}
+
+func newDataset(opts Options) *dataset {
+	ds := &dataset{
+		videos:     make(map[int64]*mockVideo),
+		bvids:      make(map[string]*mockVideo),
+		pages:      make(map[int64]*mockVideo),
+		streamSize: opts.StreamSize,
+	}
+	now := time.Now().Unix()
+	aid := int64(100000)
+	for f := range opts.Folders {
+		folder := &mockFolder{ID: 1000 + f, Title: fmt.Sprintf("模拟收藏夹%d", f+1)}
+		for v := range opts.Videos {
+			aid++
+			video := &mockVideo{
+				Aid:       aid,
+				Bvid:      fmt.Sprintf("BV1mock%05d", aid%100000),
+				Title:     fmt.Sprintf("模拟投稿%d-%d", f+1, v+1),
+				UpperMid:  int64(2000 + v%3),
+				UpperName: fmt.Sprintf("模拟UP主%d", v%3+1),
+				TypeID:    int32(17 + v%2),
+				Pubdate:   now - int64(f*opts.Videos+v+1)*86400,
+			}
+			for p := range opts.Pages {
+				cid := aid*10 + int64(p+1)
+				video.Pages = append(video.Pages, mockPage{Cid: cid, Part: fmt.Sprintf("P%d", p+1), Duration: 60 * int64(p+1)})
+				ds.pages[cid] = video
+			}
+			ds.videos[video.Aid] = video
+			ds.bvids[video.Bvid] = video
+			folder.Videos = append(folder.Videos, video)
+			folder.FavAt = append(folder.FavAt, now-int64(v+1)*3600)
+		}
+		ds.folders = append(ds.folders, folder)
+	}
+	// 最后一个收藏夹同时收藏第一个投稿, 用于测试跨收藏夹去重
+	if len(ds.folders) > 1 && opts.Videos > 0 {
+		last := ds.folders[len(ds.folders)-1]
+		last.Videos = append(last.Videos, ds.folders[0].Videos[0])
+		last.FavAt = append(last.FavAt, now-30*60)
+	}
+	return ds
+}
+
+func (ds *dataset) folder(id int) *mockFolder {
+	for _, f := range ds.folders {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// 获取音视频流数据, 同一路径每次返回相同内容
+func (ds *dataset) stream(path string) []byte {
+	if data, ok := ds.streams.Load(path); ok {
+		return data.([]byte)
+	}
+	var seed int64
+	for _, c := range path {
+		seed = seed*31 + int64(c)
+	}
+	data := make([]byte, ds.streamSize)
+	rand.New(rand.NewSource(seed)).Read(data)
+	actual, _ := ds.streams.LoadOrStore(path, data)
+	return actual.([]byte)
+}
@@ -0,0 +1,108 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	archiveapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/app/archive/v1"
+	viewapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/app/view/v1"
+	dmapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/community/service/dm/v1"
+)
+
+// gRPC 接口, 只实现留档使用的 View 和 DmSegMobile
+
+// 每个分P的弹幕数量, 只有第一个分段有弹幕
+const danmakuPerPage = 20
+
+type viewServer struct {
+	viewapi.UnimplementedViewServer
+	data *dataset
+}
+
+func (vs *viewServer) View(ctx context.Context, req *viewapi.ViewReq) (*viewapi.ViewReply, error) {
+	if err := checkAuth(ctx); err != nil {
+		return nil, err
+	}
+	v, ok := vs.data.videos[req.Aid]
+	if !ok {
+		v, ok = vs.data.bvids[req.Bvid]
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "啥都木有")
+	}
+	var duration int64
+	reply := &viewapi.ViewReply{Bvid: v.Bvid}
+	for i, p := range v.Pages {
+		duration += p.Duration
+		reply.Pages = append(reply.Pages, &viewapi.ViewPage{Page: &archiveapi.Page{
+			Cid:      p.Cid,
+			Page:     int32(i + 1),
+			From:     "vupload",
+			Part:     p.Part,
+			Duration: p.Duration,
+		}})
+	}
+	reply.Arc = &archiveapi.Arc{
+		Aid:      v.Aid,
+		Videos:   int64(len(v.Pages)),
+		TypeId:   v.TypeID,
+		TypeName: fmt.Sprintf("分区%d", v.TypeID),
+		Pic:      vs.data.baseURL + fmt.Sprintf("/cover/%d.jpg", v.Aid),
+		Title:    v.Title,
+		Pubdate:  v.Pubdate,
+		Ctime:    v.Pubdate,
+		Desc:     "模拟投稿简介",
+		Duration: duration,
+		Author:   &archiveapi.Author{Mid: v.UpperMid, Name: v.UpperName},
+		FirstCid: v.Pages[0].Cid,
+	}
+	return reply, nil
+}
+
+type dmServer struct {
+	dmapi.UnimplementedDMServer
+	data *dataset
+}
+
+func (ds *dmServer) DmSegMobile(ctx context.Context, req *dmapi.DmSegMobileReq) (*dmapi.DmSegMobileReply, error) {
+	if err := checkAuth(ctx); err != nil {
+		return nil, err
+	}
+	if _, ok := ds.data.pages[req.Oid]; !ok {
+		return nil, status.Error(codes.NotFound, "啥都木有")
+	}
+	reply := &dmapi.DmSegMobileReply{}
+	if req.SegmentIndex != 1 {
+		return reply, nil
+	}
+	now := time.Now().Unix()
+	for i := range danmakuPerPage {
+		id := req.Oid*1000 + int64(i)
+		reply.Elems = append(reply.Elems, &dmapi.DanmakuElem{
+			Id:       id,
+			Progress: int32(i * 1500),
+			Mode:     1,
+			Fontsize: 25,
+			Color:    0xffffff,
+			MidHash:  fmt.Sprintf("%08x", id),
+			Content:  fmt.Sprintf("模拟弹幕%d", i+1),
+			Ctime:    now,
+			IdStr:    fmt.Sprint(id),
+		})
+	}
+	return reply, nil
+}
+
+// 检查请求是否带有 access_key
+func checkAuth(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if auth := md.Get("authorization"); len(auth) == 0 || auth[0] == "identify_v1 " {
+		return status.Error(codes.Unauthenticated, "账号未登录")
+	}
+	return nil
+}
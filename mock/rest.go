@@ -0,0 +1,220 @@
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+// BApiClient 使用的 REST 接口, 响应格式与B站一致
+
+func (s *Server) registerREST(mux *http.ServeMux) {
+	// 账号
+	mux.HandleFunc("/x/web-interface/nav", s.requireLogin(s.handleNav))
+	mux.HandleFunc("/x/passport-tv-login/qrcode/auth_code", s.handleQRCode)
+	mux.HandleFunc("/x/passport-tv-login/qrcode/poll", s.handleQRPoll)
+	mux.HandleFunc("/x/passport-login/oauth2/refresh_token", s.handleRefreshToken)
+	mux.HandleFunc("/x/passport-login/oauth2/info", s.handleTokenInfo)
+	// 收藏夹
+	mux.HandleFunc("/x/v3/fav/folder/created/list-all", s.requireLogin(s.handleFavList))
+	mux.HandleFunc("/x/v3/fav/resource/list", s.requireLogin(s.handleFavMediaList))
+	mux.HandleFunc("/x/v3/fav/folder/collected/list", s.requireLogin(s.handleEmptyList))
+	mux.HandleFunc("/x/v2/history/toview", s.requireLogin(s.handleEmptyList))
+	// 播放地址
+	mux.HandleFunc("/x/player/playurl", s.requireLogin(s.handlePlayURL))
+	// 未模拟的接口
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, -404, "啥都木有")
+	})
+}
+
+func writeData(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(internal.BiliResp{Code: 0, Message: "0", Data: data})
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(internal.BiliResp{Code: code, Message: message})
+}
+
+// 请求参数, GET 使用 query, POST 使用表单
+func formInt(r *http.Request, key string) int {
+	v, _ := strconv.Atoi(r.FormValue(key))
+	return v
+}
+
+// 检查 SESSDATA, 未登录时返回 -101
+func (s *Server) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie("SESSDATA")
+		if err != nil || c.Value != s.cookieInfo().AccessToken {
+			writeError(w, -101, "账号未登录")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleNav(w http.ResponseWriter, r *http.Request) {
+	var uf internal.UserInfoStruct
+	uf.Mid = s.opts.Mid
+	uf.Uname = s.opts.Uname
+	uf.WbiImg.ImgUrl = "https://i0.hdslb.com/bfs/wbi/7cd084941338484aae1ad9425b84077c.png"
+	uf.WbiImg.SubUrl = "https://i0.hdslb.com/bfs/wbi/4932caff0ff746eab6f01bf08b70ac45.png"
+	writeData(w, uf)
+}
+
+// 扫码登录, 轮询时直接返回登录成功
+func (s *Server) handleQRCode(w http.ResponseWriter, r *http.Request) {
+	writeData(w, internal.QRCodeStruct{
+		AuthCode: "mock_auth_code",
+		Url:      s.URL() + "/qrcode?auth_code=mock_auth_code",
+	})
+}
+
+func (s *Server) handleQRPoll(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("auth_code") != "mock_auth_code" {
+		writeError(w, 86038, "二维码已失效")
+		return
+	}
+	writeData(w, s.cookieInfo())
+}
+
+func (s *Server) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("refresh_token") != s.cookieInfo().RefreshToken {
+		writeError(w, -101, "账号未登录")
+		return
+	}
+	writeData(w, s.cookieInfo())
+}
+
+func (s *Server) handleTokenInfo(w http.ResponseWriter, r *http.Request) {
+	info := s.cookieInfo()
+	if r.FormValue("access_token") != info.AccessToken {
+		writeError(w, -101, "账号未登录")
+		return
+	}
+	writeData(w, internal.TokenInfoStruct{
+		Mid:         int64(s.opts.Mid),
+		AccessToken: info.AccessToken,
+		ExpiresIn:   info.ExpiresIn,
+	})
+}
+
+func (s *Server) handleFavList(w http.ResponseWriter, r *http.Request) {
+	list := []map[string]any{}
+	if formInt(r, "up_mid") == s.opts.Mid {
+		for _, f := range s.data.folders {
+			list = append(list, map[string]any{
+				"id":          f.ID,
+				"fid":         f.ID / 100,
+				"mid":         s.opts.Mid,
+				"title":       f.Title,
+				"media_count": len(f.Videos),
+			})
+		}
+	}
+	writeData(w, map[string]any{"count": len(list), "list": list, "has_more": false})
+}
+
+// 收藏夹内容, 按收藏时间倒序分页
+func (s *Server) handleFavMediaList(w http.ResponseWriter, r *http.Request) {
+	f := s.data.folder(formInt(r, "media_id"))
+	if f == nil {
+		writeError(w, -400, "请求错误")
+		return
+	}
+	pn, ps := max(formInt(r, "pn"), 1), formInt(r, "ps")
+	if ps <= 0 {
+		ps = 20
+	}
+	var result internal.FavMediaListStruct
+	result.Info.ID = f.ID
+	result.Info.Title = f.Title
+	result.Info.Mid = int64(s.opts.Mid)
+	result.Info.MediaCount = len(f.Videos)
+	for i := (pn - 1) * ps; i < min(pn*ps, len(f.Videos)); i++ {
+		v := f.Videos[i]
+		media := internal.FavMediaStruct{
+			ID:       v.Aid,
+			Type:     2,
+			Title:    v.Title,
+			Cover:    s.data.baseURL + fmt.Sprintf("/cover/%d.jpg", v.Aid),
+			Page:     len(v.Pages),
+			Duration: int(v.Pages[0].Duration),
+			Link:     "bilibili://video/" + strconv.FormatInt(v.Aid, 10),
+			Ctime:    int(v.Pubdate),
+			Pubtime:  int(v.Pubdate),
+			FavTime:  int(f.FavAt[i]),
+			BvID:     v.Bvid,
+			Bvid:     v.Bvid,
+		}
+		media.Upper.Mid = int(v.UpperMid)
+		media.Upper.Name = v.UpperName
+		media.Ugc.FirstCid = v.Pages[0].Cid
+		result.Medias = append(result.Medias, media)
+	}
+	result.HasMore = pn*ps < len(f.Videos)
+	writeData(w, result)
+}
+
+func (s *Server) handleEmptyList(w http.ResponseWriter, r *http.Request) {
+	writeData(w, map[string]any{"count": 0, "list": []any{}, "has_more": false})
+}
+
+// 播放地址, 每个分P提供两种画质的视频流和一个音频流, 备用链接指向同一 CDN 的镜像路径
+func (s *Server) handlePlayURL(w http.ResponseWriter, r *http.Request) {
+	cid := int64(formInt(r, "cid"))
+	v, ok := s.data.pages[cid]
+	if !ok || v.Aid != int64(formInt(r, "avid")) {
+		writeError(w, -404, "啥都木有")
+		return
+	}
+	var duration int64
+	for _, p := range v.Pages {
+		if p.Cid == cid {
+			duration = p.Duration
+		}
+	}
+	stream := func(id int, codecs string, codecid, width, height int) internal.DashStream {
+		path := fmt.Sprintf("/upgcxcode/%d/%d-1-%d.m4s", cid, cid, 30000+id)
+		return internal.DashStream{
+			ID:        id,
+			BaseURL:   s.URL() + path,
+			BackupURL: []string{s.URL() + "/mirror" + path},
+			Bandwidth: 1000 * id,
+			MimeType:  "video/mp4",
+			Codecs:    codecs,
+			Codecid:   codecid,
+			Width:     width,
+			Height:    height,
+			FrameRate: "30",
+		}
+	}
+	var result internal.PlayInfoStruct
+	result.From = "local"
+	result.Result = "suee"
+	result.Quality = 80
+	result.Format = "flv"
+	result.Timelength = int(duration * 1000)
+	result.AcceptQuality = []int{80, 64}
+	result.AcceptDescription = []string{"高清 1080P", "高清 720P"}
+	result.Dash.Duration = int(duration)
+	json.Unmarshal([]byte(`[
+		{"quality": 80, "format": "flv", "new_description": "1080P 高清", "display_desc": "1080P", "codecs": ["avc1.640032"]},
+		{"quality": 64, "format": "flv720", "new_description": "720P 准高清", "display_desc": "720P", "codecs": ["avc1.640028"]}
+	]`), &result.SupportFormats)
+	result.Dash.Video = []internal.DashStream{
+		stream(80, "avc1.640032", 7, 1920, 1080),
+		stream(64, "avc1.640028", 7, 1280, 720),
+	}
+	audio := stream(280, "mp4a.40.2", 0, 0, 0)
+	audio.MimeType = "audio/mp4"
+	audio.ID = 30280
+	result.Dash.Audio = []internal.DashStream{audio}
+	writeData(w, result)
+}
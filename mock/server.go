@@ -0,0 +1,173 @@
+package mock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	viewapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/app/view/v1"
+	dmapi "github.com/XiaoMiku01/bilibili-grpc-api-go/bilibili/community/service/dm/v1"
+
+	"github.com/XiaoMiku01/bilibili-archiver/internal"
+)
+
+// 模拟B站服务器, 提供 BApiClient 使用的 REST 接口、gRPC View/DmSegMobile 以及支持 Range 的 CDN
+// 配置 endpoints 指向模拟服务器后, start 可以在没有账号的情况下离线运行
+
+type Options struct {
+	Addr       string // REST 接口和 CDN 监听地址
+	GRPCAddr   string // gRPC 监听地址
+	Mid        int    // 模拟账号 UID
+	Uname      string // 模拟账号昵称
+	Folders    int    // 收藏夹数量
+	Videos     int    // 每个收藏夹的投稿数量
+	Pages      int    // 每个投稿的分P数量
+	StreamSize int64  // 每个音视频流的大小(字节)
+}
+
+func (o *Options) setDefaults() {
+	if o.Addr == "" {
+		o.Addr = "127.0.0.1:0"
+	}
+	if o.GRPCAddr == "" {
+		o.GRPCAddr = "127.0.0.1:0"
+	}
+	if o.Mid == 0 {
+		o.Mid = 10000
+	}
+	if o.Uname == "" {
+		o.Uname = "模拟用户"
+	}
+	if o.Folders <= 0 {
+		o.Folders = 2
+	}
+	if o.Videos <= 0 {
+		o.Videos = 3
+	}
+	if o.Pages <= 0 {
+		o.Pages = 1
+	}
+	if o.StreamSize <= 0 {
+		o.StreamSize = 1 << 20
+	}
+}
+
+type Server struct {
+	opts Options
+	data *dataset
+
+	httpListener net.Listener
+	grpcListener net.Listener
+	httpServer   *http.Server
+	grpcServer   *grpc.Server
+}
+
+// 创建模拟服务器并开始监听, 调用 Serve 后开始处理请求
+func NewServer(opts Options) (*Server, error) {
+	opts.setDefaults()
+	s := &Server{opts: opts, data: newDataset(opts)}
+	var err error
+	s.httpListener, err = net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	s.grpcListener, err = net.Listen("tcp", opts.GRPCAddr)
+	if err != nil {
+		s.httpListener.Close()
+		return nil, err
+	}
+	s.data.baseURL = s.URL()
+
+	mux := http.NewServeMux()
+	s.registerREST(mux)
+	s.registerCDN(mux)
+	s.httpServer = &http.Server{Handler: mux}
+
+	// 客户端每10秒发送一次保活包
+	s.grpcServer = grpc.NewServer(grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             5 * time.Second,
+		PermitWithoutStream: true,
+	}))
+	viewapi.RegisterViewServer(s.grpcServer, &viewServer{data: s.data})
+	dmapi.RegisterDMServer(s.grpcServer, &dmServer{data: s.data})
+	return s, nil
+}
+
+// REST 接口和 CDN 地址
+func (s *Server) URL() string {
+	return "http://" + s.httpListener.Addr().String()
+}
+
+// gRPC 地址
+func (s *Server) GRPCURL() string {
+	return "http://" + s.grpcListener.Addr().String()
+}
+
+// 连接模拟服务器使用的接口地址配置
+func (s *Server) Endpoints() internal.EndpointConfig {
+	return internal.EndpointConfig{
+		API:      s.URL(),
+		Passport: s.URL(),
+		WWW:      s.URL(),
+		GRPC:     s.GRPCURL(),
+	}
+}
+
+// 处理请求, 直到服务器关闭或出错
+func (s *Server) Serve() error {
+	errc := make(chan error, 2)
+	go func() {
+		if err := s.httpServer.Serve(s.httpListener); !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+			return
+		}
+		errc <- nil
+	}()
+	go func() {
+		errc <- s.grpcServer.Serve(s.grpcListener)
+	}()
+	log.Info().Msgf("模拟服务器已启动: REST/CDN %s gRPC %s", s.URL(), s.GRPCURL())
+	return <-errc
+}
+
+func (s *Server) Close() error {
+	s.grpcServer.Stop()
+	return s.httpServer.Close()
+}
+
+// 写入模拟账号的 cookie 文件, 作为配置中的 user 使用
+func (s *Server) WriteCookieFile(cfName string) error {
+	info := s.cookieInfo()
+	return info.SaveToFile(cfName)
+}
+
+// 模拟账号的登录凭证, 扫码登录和刷新 cookie 时返回
+func (s *Server) cookieInfo() internal.CookieInfoStruct {
+	var info internal.CookieInfoStruct
+	token := fmt.Sprintf("mock_access_%d", s.opts.Mid)
+	info.Mid = s.opts.Mid
+	info.AccessToken = token
+	info.RefreshToken = fmt.Sprintf("mock_refresh_%d", s.opts.Mid)
+	info.ExpiresIn = 15552000
+	info.TokenInfo.Mid = s.opts.Mid
+	info.TokenInfo.AccessToken = info.AccessToken
+	info.TokenInfo.RefreshToken = info.RefreshToken
+	info.TokenInfo.ExpiresIn = info.ExpiresIn
+	data, _ := json.Marshal(map[string]any{
+		"cookies": []map[string]any{
+			{"name": "SESSDATA", "value": token, "http_only": 1},
+			{"name": "DedeUserID", "value": fmt.Sprint(s.opts.Mid)},
+			{"name": "bili_jct", "value": "mock_csrf"},
+		},
+		"domains": []string{".bilibili.com"},
+	})
+	json.Unmarshal(data, &info.CookieInfo)
+	return info
+}